@@ -0,0 +1,198 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	t "github.com/claudiobernardoromao/docker-img-deployer/types"
+)
+
+// hasDotDot tells whether a path, or any path of a colon-separated bind spec, has a ".." component
+func hasDotDot(path string) bool {
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == ':' }) {
+		if part == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// isWithin tells whether path is dir or below it. Both must be clean absolute paths.
+func isWithin(path, dir string) bool {
+	if dir == "/" {
+		return true
+	}
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// Maximum number of dangling links followed when resolving a path
+const maxDanglingLinks = 40
+
+// resolvePath resolves symbolic links in a clean absolute path. The path need not exist:
+// its deepest existing ancestor is resolved and the rest appended as is. Dangling links are
+// followed to where they point, since creating the path would create their target.
+func resolvePath(path string) (string, error) {
+	rest := ""
+	links := 0
+	for {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(resolved, rest), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			links++
+			if links > maxDanglingLinks {
+				return "", fmt.Errorf("Too many links resolving %s", path)
+			}
+			link, err := os.Readlink(path)
+			if err != nil {
+				return "", err
+			}
+			if !filepath.IsAbs(link) {
+				link = filepath.Join(filepath.Dir(path), link)
+			}
+			path = filepath.Clean(link)
+			continue
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return filepath.Join(path, rest), nil
+		}
+		rest = filepath.Join(filepath.Base(path), rest)
+		path = parent
+	}
+}
+
+// resolveWithin resolves symbolic links in path and checks it is still within root once
+// root's own links are resolved
+func resolveWithin(path, root string) (string, bool, error) {
+	resolvedRoot, err := resolvePath(filepath.Clean(root))
+	if err != nil {
+		return "", false, err
+	}
+	resolved, err := resolvePath(filepath.Clean(path))
+	if err != nil {
+		return "", false, err
+	}
+	return resolved, isWithin(resolved, resolvedRoot), nil
+}
+
+// checkBindPath checks that the host directory of a local or shared bind still resolves within
+// its bind root. Containers can replace parts of it with links after the bind spec is built.
+func checkBindPath(i *t.Instance, bind bindMount) error {
+	root := getBindRoot(i, bind)
+	_, ok, err := resolveWithin(bind.HostPath, root)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("ABORT: Bind mount %s resolves outside of %s", bind.ContainerPath, root)
+	}
+	return nil
+}
+
+// findApprovedDir returns the approved directory containing the resolved path, or an empty string
+func findApprovedDir(path string, approvedDirs []string) (string, error) {
+	for _, approved := range approvedDirs {
+		_, ok, err := resolveWithin(path, approved)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return approved, nil
+		}
+	}
+	return "", nil
+}
+
+// Mount propagation modes accepted in bind options
+var bindPropagationModes = []string{"private", "rprivate", "shared", "rshared", "slave", "rslave"}
+
+// bindOptions are the options of a bind mount, after the container path
+type bindOptions struct {
+	Mode        string // ro or rw
+	Relabel     string // z (shared SELinux label) or Z (private label)
+	Propagation string
+}
+
+// parseBindOptions parses a comma-separated list of bind options, rejecting unknown and conflicting ones
+func parseBindOptions(options string) (bindOptions, error) {
+	opts := bindOptions{}
+	if options == "" {
+		return opts, nil
+	}
+	for _, o := range strings.Split(options, ",") {
+		var field *string
+		switch {
+		case o == "ro" || o == "rw":
+			field = &opts.Mode
+		case o == "z" || o == "Z":
+			field = &opts.Relabel
+		case containsString(bindPropagationModes, o):
+			field = &opts.Propagation
+		default:
+			return opts, fmt.Errorf("unknown bind option %q", o)
+		}
+		if *field != "" && *field != o {
+			return opts, fmt.Errorf("bind options %q and %q conflict", *field, o)
+		}
+		*field = o
+	}
+	return opts, nil
+}
+
+// String returns the options in the form Docker expects, empty if there are none
+func (o bindOptions) String() string {
+	var options []string
+	for _, option := range []string{o.Mode, o.Relabel, o.Propagation} {
+		if option != "" {
+			options = append(options, option)
+		}
+	}
+	return strings.Join(options, ",")
+}
+
+// bindSpec returns the Docker bind specification of a host path mounted at containerPath
+func bindSpec(hostPath, containerPath string, opts bindOptions) string {
+	spec := hostPath + ":" + containerPath
+	if options := opts.String(); options != "" {
+		spec += ":" + options
+	}
+	return spec
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,343 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// makeBindTree creates a temporary directory holding a bind root, an outside directory and
+// links planted below the root. It returns the temporary directory.
+func makeBindTree(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "bindpath")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Resolve the temporary directory itself, in case it is below a link
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{"root/dir", "outside", "lib/sub", "libfoo", "ro"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"root/in":       filepath.Join(dir, "root/dir"),
+		"root/out":      filepath.Join(dir, "outside"),
+		"root/rel":      "../outside",
+		"root/dangling": filepath.Join(dir, "outside/missing"),
+		"root/loop":     "loop",
+		"lib/escape":    filepath.Join(dir, "libfoo"),
+		"lib/inside":    "sub",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestHasDotDot(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/usr/share/html", false},
+		{"/a/..b/c..", false},
+		{"..", true},
+		{"/a/../b", true},
+		{"/a/..", true},
+		{"../a", true},
+		{"/host:/container/..", true},
+		{"/host/..:/container", true},
+		{"/a:..", true},
+	}
+	for _, test := range tests {
+		if got := hasDotDot(test.path); got != test.want {
+			t.Errorf("hasDotDot(%q) = %v, want %v", test.path, got, test.want)
+		}
+	}
+}
+
+func TestIsWithin(t *testing.T) {
+	tests := []struct {
+		path, dir string
+		want      bool
+	}{
+		{"/var/lib", "/var/lib", true},
+		{"/var/lib/docker", "/var/lib", true},
+		{"/var/libfoo", "/var/lib", false},
+		{"/var/libfoo/x", "/var/lib", false},
+		{"/var", "/var/lib", false},
+		{"/etc/passwd", "/", true},
+	}
+	for _, test := range tests {
+		if got := isWithin(test.path, test.dir); got != test.want {
+			t.Errorf("isWithin(%q, %q) = %v, want %v", test.path, test.dir, got, test.want)
+		}
+	}
+}
+
+func TestResolveWithin(t *testing.T) {
+	dir := makeBindTree(t)
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "root")
+
+	tests := []struct {
+		path     string
+		resolved string
+		ok       bool
+		err      bool
+	}{
+		{"root/dir/x", "root/dir/x", true, false},
+		{"root/missing/x", "root/missing/x", true, false},
+		{"root/in/x", "root/dir/x", true, false},
+		{"root/out", "outside", false, false},
+		{"root/out/x", "outside/x", false, false},
+		{"root/rel/x", "outside/x", false, false},
+		{"root/dangling", "outside/missing", false, false},
+		{"root/dangling/x", "outside/missing/x", false, false},
+		{"root/loop/x", "", false, true},
+		{"outside", "outside", false, false},
+	}
+	for _, test := range tests {
+		resolved, ok, err := resolveWithin(filepath.Join(dir, test.path), root)
+		if (err != nil) != test.err {
+			t.Errorf("resolveWithin(%s) error = %v, want error %v", test.path, err, test.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if resolved != filepath.Join(dir, test.resolved) || ok != test.ok {
+			t.Errorf("resolveWithin(%s) = %s, %v, want %s, %v", test.path, resolved, ok, filepath.Join(dir, test.resolved), test.ok)
+		}
+	}
+}
+
+func TestGetPaths(t *testing.T) {
+	dir := makeBindTree(t)
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "root")
+
+	tests := []struct {
+		path string
+		rel  string
+		err  bool
+	}{
+		{"/usr/share/html", "usr/share/html", false},
+		{"/usr/share/html/", "usr/share/html", false},
+		{"/etc//nginx:ro,Z", "etc/nginx", false},
+		{"/in/x", "in/x", false},
+		{"relative/path", "", true},
+		{"/", "", true},
+		{"/:ro", "", true},
+		{"/..", "", true},
+		{"/a/../../etc", "", true},
+		{"/a/..", "", true},
+		{"/out", "", true},
+		{"/out/x", "", true},
+		{"/rel/x", "", true},
+		{"/dangling", "", true},
+		{"/dangling/x", "", true},
+	}
+	for _, test := range tests {
+		local, rel, err := getPaths(test.path, root)
+		if (err != nil) != test.err {
+			t.Errorf("getPaths(%q) error = %v, want error %v", test.path, err, test.err)
+			continue
+		}
+		if err == nil && (rel != test.rel || local != filepath.Join(root, test.rel)) {
+			t.Errorf("getPaths(%q) = %s, %s, want %s", test.path, local, rel, test.rel)
+		}
+	}
+}
+
+func TestGetBindsForHostPaths(t *testing.T) {
+	dir := makeBindTree(t)
+	defer os.RemoveAll(dir)
+	approved := []string{filepath.Join(dir, "lib")}
+	readOnly := []string{filepath.Join(dir, "ro")}
+
+	tests := []struct {
+		bind          string
+		forceReadOnly bool
+		spec          string
+		err           bool
+	}{
+		{"lib:/data", false, "lib:/data", false},
+		{"lib/sub:/data:rw,z", false, "lib/sub:/data:rw,z", false},
+		{"lib/inside:/data", false, "lib/sub:/data", false},
+		{"lib/new:/data/", false, "lib/new:/data", false},
+		{"lib/sub:/data", true, "lib/sub:/data:ro", false},
+		{"lib/sub:/data:rw", true, "", true},
+		{"ro/x:/data", false, "ro/x:/data:ro", false},
+		{"ro/x:/data:Z", false, "ro/x:/data:ro,Z", false},
+		{"ro/x:/data:rw", false, "", true},
+		{"libfoo:/data", false, "", true},
+		{"libfoo/x:/data", false, "", true},
+		{"lib/escape:/data", false, "", true},
+		{"lib/escape/x:/data", false, "", true},
+		{"lib/../libfoo:/data", false, "", true},
+		{"lib:/data/../etc", false, "", true},
+		{"lib:data", false, "", true},
+		{"lib", false, "", true},
+		{"lib:/data:bogus", false, "", true},
+		{"outside:/data", false, "", true},
+	}
+	for _, test := range tests {
+		// Not joined, which would clean the bind spec
+		binds, err := getBindsForHostPaths([]string{dir + "/" + test.bind}, approved, readOnly, test.forceReadOnly)
+		if (err != nil) != test.err {
+			t.Errorf("getBindsForHostPaths(%s) error = %v, want error %v", test.bind, err, test.err)
+			continue
+		}
+		if err == nil && (len(binds) != 1 || binds[0].Spec != dir+"/"+test.spec) {
+			t.Errorf("getBindsForHostPaths(%s) = %+v, want spec %s/%s", test.bind, binds, dir, test.spec)
+		}
+	}
+}
+
+func TestCopyPlantedLinks(t *testing.T) {
+	copies := map[string]func(source, dest string) error{
+		"copyDir": func(source, dest string) error {
+			return copyDir(source, dest, nil)
+		},
+		"copyMissing": func(source, dest string) error {
+			_, err := copyMissing(source, dest, nil)
+			return err
+		},
+	}
+	for name, copy := range copies {
+		dir := makeBindTree(t)
+		source := filepath.Join(dir, "source")
+		if err := os.MkdirAll(filepath.Join(source, "out"), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Join(source, "dangling"), 0700); err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range []string{"out/x.cfg", "dangling/x.cfg", "rel"} {
+			if err := ioutil.WriteFile(filepath.Join(source, file), []byte("x"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		outside := filepath.Join(dir, "outside")
+		past := time.Now().Add(-time.Hour).Truncate(time.Second)
+		if err := os.Chtimes(outside, past, past); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := copy(source, filepath.Join(dir, "root")); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+
+		files, err := ioutil.ReadDir(outside)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, fi := range files {
+			t.Errorf("%s: wrote %s outside of the bind", name, fi.Name())
+		}
+		fi, err := os.Stat(outside)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != 0755 || !fi.ModTime().Equal(past) {
+			t.Errorf("%s: changed the outside directory to %s %s", name, fi.Mode(), fi.ModTime())
+		}
+		if link, err := os.Readlink(filepath.Join(dir, "root/rel")); name == "copyMissing" && (err != nil || !strings.HasSuffix(link, "outside")) {
+			t.Errorf("%s: replaced existing link rel", name)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestParseBindOptions(t *testing.T) {
+	tests := []struct {
+		options string
+		want    string
+		err     bool
+	}{
+		{"", "", false},
+		{"ro", "ro", false},
+		{"rw", "rw", false},
+		{"Z,ro", "ro,Z", false},
+		{"rslave,z,rw", "rw,z,rslave", false},
+		{"ro,ro", "ro", false},
+		{"private", "private", false},
+		{"ro,rw", "", true},
+		{"z,Z", "", true},
+		{"shared,slave", "", true},
+		{"RO", "", true},
+		{"ro,", "", true},
+		{"nocopy", "", true},
+		{"ro:z", "", true},
+	}
+	for _, test := range tests {
+		opts, err := parseBindOptions(test.options)
+		if (err != nil) != test.err {
+			t.Errorf("parseBindOptions(%q) error = %v, want error %v", test.options, err, test.err)
+			continue
+		}
+		if err == nil && opts.String() != test.want {
+			t.Errorf("parseBindOptions(%q) = %q, want %q", test.options, opts.String(), test.want)
+		}
+	}
+}
+
+func TestGetBindsForPathsOptions(t *testing.T) {
+	dir := makeBindTree(t)
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "root")
+
+	tests := []struct {
+		path string
+		spec string
+		err  bool
+	}{
+		{"/etc/nginx", "/etc/nginx:/etc/nginx", false},
+		{"/etc/nginx:Z,ro", "/etc/nginx:/etc/nginx:ro,Z", false},
+		{"/data/:rw,rshared", "/data:/data:rw,rshared", false},
+		{"/data:ro,rw", "", true},
+		{"/data:bogus", "", true},
+	}
+	for _, test := range tests {
+		binds, err := getBindsForPaths("local", []string{test.path}, root, "/archive")
+		if (err != nil) != test.err {
+			t.Errorf("getBindsForPaths(%q) error = %v, want error %v", test.path, err, test.err)
+			continue
+		}
+		if err == nil && (len(binds) != 1 || binds[0].Spec != root+test.spec) {
+			t.Errorf("getBindsForPaths(%q) = %+v, want spec %s%s", test.path, binds, root, test.spec)
+		}
+	}
+}
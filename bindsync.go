@@ -0,0 +1,186 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/net/context"
+
+	t "github.com/claudiobernardoromao/docker-img-deployer/types"
+)
+
+// Lock file under the shared bind root serializing shared bind initialization across nodes
+const bindSyncLockFileName = ".docker-bind-sync.lock"
+
+// Maximum time to wait for another instance initializing the shared binds
+const bindSyncLockTimeout = 10 * time.Minute
+
+// syncBind copies the archive content of a bind into its host directory. Shared binds follow
+// their sync policy, under a lock held on the shared bind root.
+func syncBind(i *t.Instance, bind bindMount, tokens *tokenReplacer) error {
+	if _, err := os.Stat(bind.SourceDir); err != nil {
+		return nil
+	}
+	owner, err := getBindOwner(i)
+	if err != nil {
+		return err
+	}
+	if bind.Type != "shared" {
+		err = checkBindPath(i, bind)
+		if err != nil {
+			return err
+		}
+		return copyWithTokens(bind, tokens, owner)
+	}
+
+	lockCtx, cancel := context.WithTimeout(ctx, bindSyncLockTimeout)
+	defer cancel()
+	unlock, _, err := lockFile(lockCtx, filepath.Join(getSharedBindRoot(i), bindSyncLockFileName), "Another instance is initializing the shared binds")
+	if err != nil {
+		return fmt.Errorf("ABORT: Unable to lock the shared binds: %s", err)
+	}
+	defer unlock()
+
+	// Other instances may have changed the shared bind before the lock was acquired
+	err = checkBindPath(i, bind)
+	if err != nil {
+		return err
+	}
+	policy := i.GetBindSyncPolicy(bind.ContainerPath)
+	switch policy {
+	case "IfEmpty":
+		entries, err := ioutil.ReadDir(bind.HostPath)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			log.Printf("Shared bind %s is not empty, not copying archive content (%s)\n", bind.ContainerPath, policy)
+			return nil
+		}
+	case "Once":
+		markerPath := getBindSyncMarkerPath(i, bind)
+		if b, err := ioutil.ReadFile(markerPath); err == nil {
+			log.Printf("Archive content was already copied into shared bind %s by %s", bind.ContainerPath, b)
+			return nil
+		}
+		err = copyWithTokens(bind, tokens, owner)
+		if err != nil {
+			return err
+		}
+		marker := fmt.Sprintf("instance %s at %s\n", i.Workload.InstanceID, time.Now().UTC().Format(time.RFC3339))
+		return ioutil.WriteFile(markerPath, []byte(marker), 0644)
+	case "MissingOnly":
+		existing, err := copyMissing(bind.SourceDir, bind.HostPath, owner)
+		if err != nil {
+			return err
+		}
+		log.Printf("Copied archive content missing from shared bind %s, kept %d existing files\n", bind.ContainerPath, len(existing))
+		return tokens.replaceInCopy(bind.SourceDir, bind.HostPath, existing)
+	}
+	return copyWithTokens(bind, tokens, owner)
+}
+
+func copyWithTokens(bind bindMount, tokens *tokenReplacer, owner *fileOwner) error {
+	err := copyDirIfExists(bind.SourceDir, bind.HostPath, owner)
+	if err != nil {
+		return err
+	}
+	return tokens.replaceInCopy(bind.SourceDir, bind.HostPath, nil)
+}
+
+// getBindSyncMarkerPath returns the file recording that a shared bind was initialized for the version
+func getBindSyncMarkerPath(i *t.Instance, bind bindMount) string {
+	return filepath.Join(getSharedBindRoot(i), fmt.Sprintf(".docker-bind-synced-%x", sha256.Sum256([]byte(bind.ContainerPath))))
+}
+
+// copyMissing copies the files of source that do not exist under dest. It returns the
+// relative paths of the files it left alone.
+func copyMissing(source, dest string, owner *fileOwner) (map[string]bool, error) {
+	existing := map[string]bool{}
+	var dirs []string
+	dirModes := map[string]os.FileMode{}
+	err := filepath.Walk(source, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		if targetInfo, err := os.Lstat(target); err == nil {
+			if !fi.IsDir() {
+				existing[rel] = true
+				return nil
+			}
+			if targetInfo.IsDir() {
+				return checkCopyDir(target, dest)
+			}
+			// Keep whatever is in place of the directory, without following it if it is a link
+			log.Printf("Not copying %s into shared bind, %s is not a directory\n", rel, target)
+			err = addExistingFiles(existing, source, path)
+			if err != nil {
+				return err
+			}
+			return filepath.SkipDir
+		}
+		if fi.IsDir() {
+			// As in copyDir, modes of new directories are applied once they are filled
+			if rel != "." {
+				dirs = append(dirs, target)
+				dirModes[target] = fi.Mode() & copiedModeBits
+			}
+			return makeCopyDir(target, dest, 0755, owner)
+		}
+		return copyEntry(path, target, dest, fi, owner)
+	})
+	for n := len(dirs) - 1; n >= 0 && err == nil; n-- {
+		err = checkCopyDir(dirs[n], dest)
+		if err == nil {
+			err = os.Chmod(dirs[n], dirModes[dirs[n]])
+		}
+	}
+	return existing, err
+}
+
+// addExistingFiles adds the paths of the files under dir, relative to source, to existing
+func addExistingFiles(existing map[string]bool, source, dir string) error {
+	return filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		existing[rel] = true
+		return nil
+	})
+}
@@ -0,0 +1,154 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	dt "github.com/claudiobernardoromao/docker-img-deployer/types"
+)
+
+// readTree returns the content of the regular files under dir by relative path
+func readTree(t *testing.T, dir string) map[string]string {
+	files := map[string]string{}
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() {
+			return err
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		files[rel] = string(b)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestSyncBindPolicies(t *testing.T) {
+	source := map[string]string{"a.conf": "new", "sub/b.conf": "new"}
+	tests := []struct {
+		name   string
+		sync   []string
+		host   map[string]string // files already in the shared bind
+		synced bool              // whether an earlier instance recorded a Once sync
+		want   map[string]string
+	}{
+		{
+			name: "Overwrite",
+			host: map[string]string{"a.conf": "old", "extra": "old"},
+			want: map[string]string{"a.conf": "new", "sub/b.conf": "new", "extra": "old"},
+		},
+		{
+			name: "IfEmpty on an empty bind",
+			sync: []string{"IfEmpty"},
+			want: map[string]string{"a.conf": "new", "sub/b.conf": "new"},
+		},
+		{
+			name: "IfEmpty on a filled bind",
+			sync: []string{"IfEmpty"},
+			host: map[string]string{"a.conf": "old"},
+			want: map[string]string{"a.conf": "old"},
+		},
+		{
+			name: "MissingOnly",
+			sync: []string{"MissingOnly"},
+			host: map[string]string{"a.conf": "old", "extra": "old"},
+			want: map[string]string{"a.conf": "old", "sub/b.conf": "new", "extra": "old"},
+		},
+		{
+			name: "Once, first instance",
+			sync: []string{"Once"},
+			host: map[string]string{"a.conf": "old"},
+			want: map[string]string{"a.conf": "new", "sub/b.conf": "new"},
+		},
+		{
+			name:   "Once, later instance",
+			sync:   []string{"Once"},
+			host:   map[string]string{"a.conf": "old"},
+			synced: true,
+			want:   map[string]string{"a.conf": "old"},
+		},
+		{
+			name: "policy of the container path",
+			sync: []string{"/other=Overwrite", "/data=MissingOnly", "IfEmpty"},
+			host: map[string]string{"a.conf": "old"},
+			want: map[string]string{"a.conf": "old", "sub/b.conf": "new"},
+		},
+	}
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "bindsync")
+		if err != nil {
+			t.Fatal(err)
+		}
+		i := newSharedInstance(filepath.Join(dir, "shared"), "web", "2")
+		if test.sync != nil {
+			i.Workload.CustomProps = append(i.Workload.CustomProps, dt.CustomProp{Name: dt.PropDockerBindSharedSync, Values: test.sync})
+		}
+		bind := bindMount{
+			Type:          "shared",
+			HostPath:      filepath.Join(getSharedBindRoot(i), "data"),
+			ContainerPath: "/data",
+			SourceDir:     filepath.Join(dir, "source"),
+		}
+		for root, files := range map[string]map[string]string{bind.SourceDir: source, bind.HostPath: test.host} {
+			if err := os.MkdirAll(root, 0755); err != nil {
+				t.Fatal(err)
+			}
+			for name, content := range files {
+				path := filepath.Join(root, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		markerPath := getBindSyncMarkerPath(i, bind)
+		if test.synced {
+			if err := ioutil.WriteFile(markerPath, []byte("instance 1\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := syncBind(i, bind, newTokenReplacer(i)); err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		}
+		if got := readTree(t, bind.HostPath); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: shared bind holds %v, want %v", test.name, got, test.want)
+		}
+		if _, err := os.Stat(markerPath); (err == nil) != (i.GetBindSyncPolicy(bind.ContainerPath) == "Once") {
+			t.Errorf("%s: sync marker present = %v", test.name, err == nil)
+		}
+		os.RemoveAll(dir)
+	}
+}
@@ -0,0 +1,267 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	t "github.com/claudiobernardoromao/docker-img-deployer/types"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// Label recording the hash of the build context an image was built from
+const labelContextHash = "com.apprenda.deployer.context-hash"
+
+// Repository prefix of images built from a Dockerfile in the archive
+const buildRepoPrefix = "apprenda-build"
+
+var invalidRepoChars = regexp.MustCompile(`[^a-z0-9._-]+`)
+var invalidTagChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// isDockerfileDeploy tells whether the image is built from a Dockerfile in the archive
+func isDockerfileDeploy(i *t.Instance) bool {
+	return i.GetPropEnum(t.PropDockerDeploy) == "Dockerfile"
+}
+
+// getBuildImageRef returns the deterministic reference of the image built for the component
+// version, e.g. apprenda-build/tenant/app/component:v1
+func getBuildImageRef(i *t.Instance) string {
+	parts := []string{buildRepoPrefix}
+	for _, alias := range []string{i.TenantAlias(), i.Workload.ApplicationAlias, i.Workload.BundleName} {
+		parts = append(parts, strings.Trim(invalidRepoChars.ReplaceAllString(strings.ToLower(alias), "-"), "-._"))
+	}
+	tag := strings.TrimLeft(invalidTagChars.ReplaceAllString(i.Workload.VersionAlias, "-"), "-.")
+	return strings.Join(parts, "/") + ":" + tag
+}
+
+// imageBuild builds the Dockerfile in the component folder of the archive and tags it as ref.
+// An image already tagged as ref is reused if it was built from the same context.
+func imageBuild(cli *client.Client, i *t.Instance, ref string) error {
+	contextDir := getArchiveSrcDir(i)
+	if _, err := os.Stat(filepath.Join(contextDir, "Dockerfile")); err != nil {
+		return fmt.Errorf("ABORT: DockerDeploy is Dockerfile but there is no Dockerfile in %s", contextDir)
+	}
+
+	err := checkBuildImagePolicy(i, filepath.Join(contextDir, "Dockerfile"))
+	if err != nil {
+		return err
+	}
+
+	buildContext, err := ioutil.TempFile("", "docker-build-context")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(buildContext.Name())
+	defer buildContext.Close()
+
+	contextHash, err := writeBuildContext(buildContext, contextDir)
+	if err != nil {
+		return fmt.Errorf("ABORT: Unable to archive build context %s: %s", contextDir, err)
+	}
+
+	// Instances of the same version on this node build one at a time and reuse the result
+	buildCtx, cancel := context.WithTimeout(ctx, i.GetPropDuration(t.PropDockerPullTimeoutSecs))
+	defer cancel()
+	unlock, _, err := lockImageRef(buildCtx, ref)
+	if err != nil {
+		return fmt.Errorf("ABORT: Unable to build %s: %s", ref, err)
+	}
+	defer unlock()
+
+	img, _, err := cli.ImageInspectWithRaw(ctx, ref)
+	if err == nil && img.Config != nil && img.Config.Labels[labelContextHash] == contextHash && !i.GetPropBool(t.PropDockerForcePull) {
+		log.Printf("Image %s was already built from this archive, reusing %s\n", ref, img.ID)
+		return nil
+	}
+
+	_, err = buildContext.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	options := types.ImageBuildOptions{
+		Tags:        []string{ref},
+		Dockerfile:  "Dockerfile",
+		Labels:      map[string]string{labelContextHash: contextHash},
+		PullParent:  i.GetPropBool(t.PropDockerForcePull),
+		Remove:      true,
+		ForceRemove: true,
+	}
+	existing := getLocalImageIDs(cli)
+	log.Printf("Building %s from %s\n", ref, contextDir)
+	resp, err := cli.ImageBuild(buildCtx, buildContext, options)
+	if err != nil {
+		return fmt.Errorf("ABORT: Unable to build %s: %s", ref, err)
+	}
+	defer resp.Body.Close()
+	_, err = readProgress(resp.Body, "Building "+ref)
+	if err != nil {
+		return fmt.Errorf("ABORT: Unable to build %s: %s", ref, err)
+	}
+	log.Println("Image built:", ref)
+	trackImage(cli, ref, existing)
+	return nil
+}
+
+// checkBuildImagePolicy aborts the deployment if a base image of the Dockerfile is not allowed
+// by the host image policy, since the build pulls them from their registries
+func checkBuildImagePolicy(i *t.Instance, dockerfile string) error {
+	policy, err := loadImagePolicy()
+	if err != nil || policy == nil {
+		return err
+	}
+	f, err := os.Open(dockerfile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	bases, err := getDockerfileBaseImages(f)
+	if err != nil {
+		return fmt.Errorf("ABORT: Unable to read %s: %s", dockerfile, err)
+	}
+	for _, base := range bases {
+		if strings.Contains(base, "$") {
+			return fmt.Errorf("ABORT: Base image %s uses a build argument and cannot be checked against the image policy", base)
+		}
+		err = checkImagePolicy(i, base)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getDockerfileBaseImages returns the images named by the FROM instructions of a Dockerfile,
+// leaving out scratch and earlier build stages. Images without a tag or digest get the latest
+// tag, as the build would.
+func getDockerfileBaseImages(r io.Reader) ([]string, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	bases := []string{}
+	stages := map[string]bool{"scratch": true}
+	instruction := ""
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		// Join continuation lines into a single instruction
+		if strings.HasSuffix(line, "\\") {
+			instruction += strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+		fields := strings.Fields(instruction + line)
+		instruction = ""
+		if len(fields) == 0 || !strings.EqualFold(fields[0], "FROM") {
+			continue
+		}
+		fields = fields[1:]
+		for len(fields) > 0 && strings.HasPrefix(fields[0], "--") {
+			fields = fields[1:]
+		}
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("FROM without an image")
+		}
+		image := fields[0]
+		if !stages[strings.ToLower(image)] {
+			if _, _, tag, digest := parseImageRef(image); tag == "" && digest == "" && !strings.Contains(image, "$") {
+				image += ":latest"
+			}
+			bases = append(bases, image)
+		}
+		if len(fields) >= 3 && strings.EqualFold(fields[1], "AS") {
+			stages[strings.ToLower(fields[2])] = true
+		}
+	}
+	return bases, nil
+}
+
+// writeBuildContext writes dir as a tar archive to w. It returns a hash of the names, modes and
+// contents of the files, which unlike the archive itself does not depend on modification times.
+func writeBuildContext(w io.Writer, dir string) (string, error) {
+	tw := tar.NewWriter(w)
+	h := sha256.New()
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		link := ""
+		if fi.Mode()&os.ModeSymlink != 0 {
+			link, err = os.Readlink(path)
+			if err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = rel
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		err = tw.WriteHeader(hdr)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%o\x00%s\x00", hdr.Name, fi.Mode(), link)
+
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(io.MultiWriter(tw, h), f)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	err = tw.Close()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
@@ -0,0 +1,59 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGetDockerfileBaseImages(t *testing.T) {
+	tests := []struct {
+		dockerfile string
+		want       []string
+		err        bool
+	}{
+		{"FROM nginx:1.11\nCOPY . /usr/share/nginx/html\n", []string{"nginx:1.11"}, false},
+		{"from nginx\n", []string{"nginx:latest"}, false},
+		{"FROM registry.example.com:5000/team/base\n", []string{"registry.example.com:5000/team/base:latest"}, false},
+		{"FROM nginx@sha256:0123\n", []string{"nginx@sha256:0123"}, false},
+		{"# FROM evil/image\nFROM nginx:1\n", []string{"nginx:1"}, false},
+		{"FROM --platform=linux/amd64 golang:1.8 AS build\nRUN make\nFROM scratch\nCOPY --from=build /app /app\n", []string{"golang:1.8"}, false},
+		{"FROM golang:1.8 as Build\nFROM build\nFROM alpine:3.5\n", []string{"golang:1.8", "alpine:3.5"}, false},
+		{"FROM \\\n  evil.example.com/image:1\n", []string{"evil.example.com/image:1"}, false},
+		{"ARG BASE=nginx\nFROM ${BASE}\n", []string{"${BASE}"}, false},
+		{"RUN echo FROM evil/image\n", []string{}, false},
+		{"FROM\n", nil, true},
+	}
+	for _, test := range tests {
+		got, err := getDockerfileBaseImages(strings.NewReader(test.dockerfile))
+		if (err != nil) != test.err {
+			t.Errorf("getDockerfileBaseImages(%q) error = %v, want error %v", test.dockerfile, err, test.err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("getDockerfileBaseImages(%q) = %q, want %q", test.dockerfile, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	t "github.com/claudiobernardoromao/docker-img-deployer/types"
+)
+
+// File mode bits preserved by copies. Setuid and setgid are never copied from the archive, which is
+// supplied by the tenant.
+const copiedModeBits = os.ModePerm | os.ModeSticky
+
+// fileOwner is the owner given to bind directories and copied archive content
+type fileOwner struct {
+	UID, GID int
+}
+
+// getBindOwner returns the owner set by DockerBindOwner, or nil to leave files owned by the deployer
+func getBindOwner(i *t.Instance) (*fileOwner, error) {
+	value := i.GetPropValid(t.PropDockerBindOwner)
+	if value == "" {
+		return nil, nil
+	}
+	parts := strings.SplitN(value, ":", 2)
+	uid, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("ABORT: Invalid %s %q", t.PropDockerBindOwner, value)
+	}
+	gid := uid
+	if len(parts) == 2 {
+		gid, err = strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("ABORT: Invalid %s %q", t.PropDockerBindOwner, value)
+		}
+	}
+	return &fileOwner{UID: uid, GID: gid}, nil
+}
+
+func (o *fileOwner) chown(path string) error {
+	if o == nil {
+		return nil
+	}
+	return os.Lchown(path, o.UID, o.GID)
+}
+
+func copyDirIfExists(source, dest string, owner *fileOwner) error {
+	// check if the source exists and is a directory
+	src, err := os.Stat(source)
+	if err != nil {
+		return nil
+	}
+	if !src.IsDir() {
+		return errors.New("ABORT: Source is not a directory")
+	}
+	return copyDir(source, dest, owner)
+}
+
+// copyDir copies the tree under source to dest, preserving symbolic links, modes and modification
+// times. It carries on past failures and returns them all in a single error.
+func copyDir(source, dest string, owner *fileOwner) error {
+	var failures []string
+	var dirs []string
+	dirInfos := map[string]os.FileInfo{}
+
+	err := filepath.Walk(source, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			failures = append(failures, err.Error())
+			if fi != nil && fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		if fi.IsDir() {
+			// Directory modes and times are applied last, since copying into them changes their times
+			// and a read-only mode would prevent it
+			err = makeCopyDir(target, dest, 0755, owner)
+			if err != nil {
+				failures = append(failures, err.Error())
+				return filepath.SkipDir
+			}
+			// The destination itself keeps the mode it was created with
+			if rel != "." {
+				dirs = append(dirs, target)
+				dirInfos[target] = fi
+			}
+			return nil
+		}
+		err = copyEntry(path, target, dest, fi, owner)
+		if err != nil {
+			failures = append(failures, err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		failures = append(failures, err.Error())
+	}
+
+	for n := len(dirs) - 1; n >= 0; n-- {
+		fi := dirInfos[dirs[n]]
+		// Chmod and Chtimes follow links, so check nothing replaced the directory in the meantime
+		err = checkCopyDir(dirs[n], dest)
+		if err == nil {
+			err = os.Chmod(dirs[n], fi.Mode()&copiedModeBits)
+		}
+		if err == nil {
+			err = os.Chtimes(dirs[n], fi.ModTime(), fi.ModTime())
+		}
+		if err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("ABORT: Unable to copy %s to %s, %d errors: %s", source, dest, len(failures), strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// makeCopyDir creates the directory dest below root, or uses the one in place. A link in its place
+// is replaced with a directory rather than followed, since a container may have planted it to
+// redirect the copy out of the bind. Any other kind of file is an error.
+func makeCopyDir(dest, root string, perm os.FileMode, owner *fileOwner) error {
+	if dest == root {
+		err := os.MkdirAll(dest, perm)
+		if err != nil {
+			return err
+		}
+		return owner.chown(dest)
+	}
+	err := checkCopyTarget(filepath.Dir(dest), root)
+	if err != nil {
+		return err
+	}
+	existing, err := os.Lstat(dest)
+	switch {
+	case err == nil && existing.IsDir():
+	case err == nil && existing.Mode()&os.ModeSymlink != 0:
+		log.Printf("Replacing link %s with a directory\n", dest)
+		err = os.Remove(dest)
+		if err == nil {
+			err = os.Mkdir(dest, perm)
+		}
+	case err == nil:
+		err = fmt.Errorf("%s exists and is not a directory", dest)
+	case os.IsNotExist(err):
+		err = os.Mkdir(dest, perm)
+	}
+	if err != nil {
+		return err
+	}
+	err = checkCopyDir(dest, root)
+	if err != nil {
+		return err
+	}
+	return owner.chown(dest)
+}
+
+// checkCopyDir checks that dest is a directory, not a link to one, and resolves within root
+func checkCopyDir(dest, root string) error {
+	fi, err := os.Lstat(dest)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", dest)
+	}
+	return checkCopyTarget(dest, root)
+}
+
+// checkCopyTarget checks that path still resolves within root once links are followed
+func checkCopyTarget(path, root string) error {
+	_, ok, err := resolveWithin(path, root)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s resolves outside of %s", path, root)
+	}
+	return nil
+}
+
+// copyEntry copies a file or symbolic link described by fi into the directory tree under root.
+// Other kinds of files are skipped.
+func copyEntry(source, dest, root string, fi os.FileInfo, owner *fileOwner) error {
+	err := checkCopyTarget(filepath.Dir(dest), root)
+	if err != nil {
+		return err
+	}
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		link, err := os.Readlink(source)
+		if err != nil {
+			return err
+		}
+		if existing, err := os.Lstat(dest); err == nil && !existing.IsDir() {
+			err = os.Remove(dest)
+			if err != nil {
+				return err
+			}
+		}
+		err = os.Symlink(link, dest)
+		if err != nil {
+			return err
+		}
+		return owner.chown(dest)
+	case fi.Mode().IsRegular():
+		return copyFile(source, dest, fi, owner)
+	}
+	log.Printf("Not copying %s, it is not a regular file, directory or symbolic link\n", source)
+	return nil
+}
+
+// copyFile copies a regular file and verifies the copy has the same size and checksum
+func copyFile(source, dest string, fi os.FileInfo, owner *fileOwner) error {
+	sourcefile, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer sourcefile.Close()
+
+	// Replace links rather than write through them
+	if existing, err := os.Lstat(dest); err == nil && existing.Mode()&os.ModeSymlink != 0 {
+		err = os.Remove(dest)
+		if err != nil {
+			return err
+		}
+	}
+	destfile, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|syscall.O_NOFOLLOW, 0600)
+	if err != nil {
+		return err
+	}
+	sourceHash := sha256.New()
+	n, err := io.Copy(destfile, io.TeeReader(sourcefile, sourceHash))
+	if closeErr := destfile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	destHash, err := fileSHA256(dest)
+	if err != nil {
+		return err
+	}
+	if n != fi.Size() || destHash != fmt.Sprintf("%x", sourceHash.Sum(nil)) {
+		return fmt.Errorf("copy of %s to %s does not match the source", source, dest)
+	}
+
+	err = owner.chown(dest)
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(dest, fi.Mode()&copiedModeBits)
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(dest, fi.ModTime(), fi.ModTime())
+}
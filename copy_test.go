@@ -0,0 +1,155 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCopyModes(t *testing.T) {
+	tests := []struct {
+		name string
+		dir  bool
+		mode os.FileMode
+		want os.FileMode
+	}{
+		{"plain", false, 0640, 0640},
+		{"executable", false, 0755, 0755},
+		{"setuid", false, 0755 | os.ModeSetuid, 0755},
+		{"setgid", false, 0755 | os.ModeSetgid, 0755},
+		{"dir", true, 0750, 0750},
+		{"setgid-dir", true, 0775 | os.ModeSetgid, 0775},
+		{"sticky-dir", true, 0777 | os.ModeSticky, 0777 | os.ModeSticky},
+	}
+	copies := map[string]func(source, dest string) error{
+		"copyDir": func(source, dest string) error {
+			return copyDir(source, dest, nil)
+		},
+		"copyMissing": func(source, dest string) error {
+			_, err := copyMissing(source, dest, nil)
+			return err
+		},
+	}
+	for name, copy := range copies {
+		dir, err := ioutil.TempDir("", "copy")
+		if err != nil {
+			t.Fatal(err)
+		}
+		source := filepath.Join(dir, "source")
+		if err := os.Mkdir(source, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for _, test := range tests {
+			path := filepath.Join(source, test.name)
+			if test.dir {
+				err = os.Mkdir(path, 0755)
+			} else {
+				err = ioutil.WriteFile(path, []byte(test.name), 0600)
+			}
+			if err == nil {
+				err = os.Chmod(path, test.mode)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		dest := filepath.Join(dir, "dest")
+		if err := copy(source, dest); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+		for _, test := range tests {
+			fi, err := os.Lstat(filepath.Join(dest, test.name))
+			if err != nil {
+				t.Errorf("%s: %s", name, err)
+				continue
+			}
+			if mode := fi.Mode() &^ os.ModeDir; mode != test.want {
+				t.Errorf("%s: %s copied with mode %s, want %s", name, test.name, mode, test.want)
+			}
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestCopyEscapingLinks(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string // file copied from the archive
+		link   string // link planted in the destination
+		target string // target of the link, with OUT standing for the outside directory
+	}{
+		{"file over absolute link", "x.cfg", "x.cfg", "OUT/x.cfg"},
+		{"file over relative link", "x.cfg", "x.cfg", "../outside/x.cfg"},
+		{"file over dangling link", "x.cfg", "x.cfg", "OUT/missing/x.cfg"},
+		{"dir over absolute link", "conf/x.cfg", "conf", "OUT"},
+		{"dir over relative link", "conf/x.cfg", "conf", "../outside"},
+		{"dir over link chain", "conf/x.cfg", "conf", "chain"},
+	}
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "copy")
+		if err != nil {
+			t.Fatal(err)
+		}
+		dir, err = filepath.EvalSymlinks(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		source := filepath.Join(dir, "source")
+		dest := filepath.Join(dir, "dest")
+		outside := filepath.Join(dir, "outside")
+		for _, d := range []string{filepath.Dir(filepath.Join(source, test.source)), dest, outside} {
+			if err := os.MkdirAll(d, 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := ioutil.WriteFile(filepath.Join(source, test.source), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(outside, filepath.Join(dest, "chain")); err != nil {
+			t.Fatal(err)
+		}
+		target := strings.Replace(test.target, "OUT", outside, 1)
+		if err := os.Symlink(target, filepath.Join(dest, test.link)); err != nil {
+			t.Fatal(err)
+		}
+
+		copyDir(source, dest, nil)
+
+		files, err := ioutil.ReadDir(outside)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, fi := range files {
+			t.Errorf("%s: wrote %s outside of the destination", test.name, fi.Name())
+		}
+		if _, err := os.Stat(filepath.Join(dest, test.source)); err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		}
+		os.RemoveAll(dir)
+	}
+}
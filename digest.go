@@ -0,0 +1,175 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	t "github.com/claudiobernardoromao/docker-img-deployer/types"
+	"github.com/docker/docker/client"
+)
+
+// File under the shared bind root of an application version recording pinned image digests
+const imagePinsFileName = ".docker-image-pins.json"
+
+// Maximum time to wait for another instance recording a pin
+const imagePinsLockTimeout = 5 * time.Minute
+
+func getImagePinsPath(i *t.Instance) string {
+	return filepath.Join(getSharedBindRoot(i), imagePinsFileName)
+}
+
+// imagePinningEnabled tells whether instances of this version should deploy a pinned digest
+func imagePinningEnabled(i *t.Instance) bool {
+	switch i.GetPropEnum(t.PropDockerImagePinning) {
+	case "Never":
+		return false
+	case "NotInSandbox":
+		return !strings.EqualFold(i.Workload.Stage.Value, "Sandbox")
+	}
+	return true
+}
+
+// isDigestRef tells whether ref already designates an image by content digest
+func isDigestRef(ref string) bool {
+	return strings.Contains(ref, "@")
+}
+
+// imageRepo strips the tag or digest from an image reference
+func imageRepo(ref string) string {
+	if n := strings.Index(ref, "@"); n != -1 {
+		return ref[:n]
+	}
+	if n := strings.LastIndex(ref, ":"); n > strings.LastIndex(ref, "/") {
+		return ref[:n]
+	}
+	return ref
+}
+
+func readImagePins(i *t.Instance) (map[string]t.ImagePin, error) {
+	pins := map[string]t.ImagePin{}
+	_, err := readJSONFileIfExists(getImagePinsPath(i), &pins)
+	return pins, err
+}
+
+// getPinnedRef returns the repo@digest reference pinned for ref by an earlier instance
+// of the same component version, or ref itself if there is none
+func getPinnedRef(i *t.Instance, ref string) string {
+	if !imagePinningEnabled(i) || isDigestRef(ref) {
+		return ref
+	}
+	pins, err := readImagePins(i)
+	if err != nil {
+		log.Println("Unable to read image pins:", err)
+		return ref
+	}
+	if pin, ok := pins[i.Workload.BundleName]; ok && pin.Ref == ref {
+		log.Printf("Using %s, pinned for %s by instance %s\n", pin.Digest, ref, pin.InstanceID)
+		return pin.Digest
+	}
+	return ref
+}
+
+// pinImageDigest resolves the tag of the spec's image to a content digest and records it for
+// later instances of the same component version, then switches the spec to the digest
+func pinImageDigest(cli *client.Client, i *t.Instance, spec *containerSpec) error {
+	if !imagePinningEnabled(i) || isDigestRef(spec.Image) {
+		return nil
+	}
+	ref := spec.Image
+
+	// The pull happens before taking the pins lock, which only covers updating the pins file
+	img, _, err := cli.ImageInspectWithRaw(ctx, ref)
+	if client.IsErrImageNotFound(err) {
+		err = imagePull(cli, i, ref)
+		if err != nil {
+			return err
+		}
+		img, _, err = cli.ImageInspectWithRaw(ctx, ref)
+	}
+	if err != nil {
+		return err
+	}
+	repoDigest := getRepoDigest(ref, img.RepoDigests)
+	if repoDigest == "" {
+		log.Printf("Image %s has no registry digest, not pinning it\n", ref)
+		return nil
+	}
+	pinned, err := saveImagePin(i, ref, imageRepo(ref)+"@"+repoDigest[strings.Index(repoDigest, "@")+1:])
+	if err != nil {
+		return err
+	}
+	spec.setImage(pinned)
+	return nil
+}
+
+// saveImagePin records digest as the pin for ref in the pins file, unless another instance has
+// already pinned ref. It returns the digest all instances should use.
+func saveImagePin(i *t.Instance, ref, digest string) (string, error) {
+	err := os.MkdirAll(getSharedBindRoot(i), 0755)
+	if err != nil {
+		return "", err
+	}
+	pinsPath := getImagePinsPath(i)
+	lockCtx, cancel := context.WithTimeout(ctx, imagePinsLockTimeout)
+	defer cancel()
+	unlock, _, err := lockFile(lockCtx, pinsPath+".lock", "Another instance is pinning "+ref)
+	if err != nil {
+		return "", fmt.Errorf("ABORT: Unable to lock the image pins in %s: %s", pinsPath, err)
+	}
+	defer unlock()
+
+	pins, err := readImagePins(i)
+	if err != nil {
+		return "", err
+	}
+	if pin, ok := pins[i.Workload.BundleName]; ok && pin.Ref == ref {
+		log.Printf("Using %s, pinned for %s by instance %s\n", pin.Digest, ref, pin.InstanceID)
+		return pin.Digest, nil
+	}
+	pins[i.Workload.BundleName] = t.ImagePin{
+		Ref:        ref,
+		Digest:     digest,
+		PinnedAt:   time.Now().UTC(),
+		InstanceID: i.Workload.InstanceID,
+	}
+	b, err := json.MarshalIndent(pins, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	err = ioutil.WriteFile(pinsPath, b, 0644)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Pinned %s to %s for all instances of this version\n", ref, digest)
+	return digest, nil
+}
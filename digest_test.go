@@ -0,0 +1,149 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	dt "github.com/claudiobernardoromao/docker-img-deployer/types"
+)
+
+// newSharedInstance returns an instance of component bundle whose shared bind root is under dir
+func newSharedInstance(dir, bundle, instanceID string) *dt.Instance {
+	i := &dt.Instance{}
+	i.Workload.Source = "/tenant/app/v1/" + bundle
+	i.Workload.ApplicationAlias = "app"
+	i.Workload.VersionAlias = "v1"
+	i.Workload.BundleName = bundle
+	i.Workload.InstanceID = instanceID
+	i.Workload.CustomProps = []dt.CustomProp{{Name: dt.PropDockerBindSharedRootDir, Values: []string{dir}}}
+	return i
+}
+
+func TestSaveImagePin(t *testing.T) {
+	type pin struct {
+		bundle, instance, ref, digest string
+	}
+	tests := []struct {
+		name  string
+		saves []pin
+		want  []string          // digest returned by each save
+		pins  map[string]string // bundle to pinned digest
+	}{
+		{
+			name:  "first pin",
+			saves: []pin{{"web", "1", "app:1", "app@sha256:a"}},
+			want:  []string{"app@sha256:a"},
+			pins:  map[string]string{"web": "app@sha256:a"},
+		},
+		{
+			name:  "second instance keeps the first pin",
+			saves: []pin{{"web", "1", "app:1", "app@sha256:a"}, {"web", "2", "app:1", "app@sha256:b"}},
+			want:  []string{"app@sha256:a", "app@sha256:a"},
+			pins:  map[string]string{"web": "app@sha256:a"},
+		},
+		{
+			name:  "components are merged",
+			saves: []pin{{"web", "1", "app:1", "app@sha256:a"}, {"worker", "2", "jobs:1", "jobs@sha256:c"}},
+			want:  []string{"app@sha256:a", "jobs@sha256:c"},
+			pins:  map[string]string{"web": "app@sha256:a", "worker": "jobs@sha256:c"},
+		},
+		{
+			name:  "changed reference replaces the pin",
+			saves: []pin{{"web", "1", "app:1", "app@sha256:a"}, {"web", "2", "app:2", "app@sha256:b"}},
+			want:  []string{"app@sha256:a", "app@sha256:b"},
+			pins:  map[string]string{"web": "app@sha256:b"},
+		},
+	}
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "pins")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var i *dt.Instance
+		for n, save := range test.saves {
+			i = newSharedInstance(dir, save.bundle, save.instance)
+			got, err := saveImagePin(i, save.ref, save.digest)
+			if err != nil || got != test.want[n] {
+				t.Errorf("%s: save %d = %q, %v, want %q", test.name, n, got, err, test.want[n])
+			}
+		}
+		saved, err := readImagePins(i)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		}
+		pins := map[string]string{}
+		for bundle, pin := range saved {
+			pins[bundle] = pin.Digest
+		}
+		if !reflect.DeepEqual(pins, test.pins) {
+			t.Errorf("%s: pins = %v, want %v", test.name, pins, test.pins)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestGetPinnedRef(t *testing.T) {
+	const pins = `{"web": {"ref": "app:1", "digest": "app@sha256:a", "instanceId": "1"}}`
+	tests := []struct {
+		name    string
+		pins    string
+		bundle  string
+		ref     string
+		pinning string
+		want    string
+	}{
+		{"no pins file", "", "web", "app:1", "", "app:1"},
+		{"pinned", pins, "web", "app:1", "", "app@sha256:a"},
+		{"other reference", pins, "web", "app:2", "", "app:2"},
+		{"other component", pins, "worker", "app:1", "", "app:1"},
+		{"digest reference", pins, "web", "app@sha256:b", "", "app@sha256:b"},
+		{"pinning disabled", pins, "web", "app:1", "Never", "app:1"},
+		{"unreadable pins", "{", "web", "app:1", "", "app:1"},
+	}
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "pins")
+		if err != nil {
+			t.Fatal(err)
+		}
+		i := newSharedInstance(dir, test.bundle, "2")
+		if test.pinning != "" {
+			i.Workload.CustomProps = append(i.Workload.CustomProps, dt.CustomProp{Name: dt.PropDockerImagePinning, Values: []string{test.pinning}})
+		}
+		if test.pins != "" {
+			if err := os.MkdirAll(getSharedBindRoot(i), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(getImagePinsPath(i), []byte(test.pins), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := getPinnedRef(i, test.ref); got != test.want {
+			t.Errorf("%s: getPinnedRef() = %q, want %q", test.name, got, test.want)
+		}
+		os.RemoveAll(dir)
+	}
+}
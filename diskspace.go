@@ -0,0 +1,153 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	t "github.com/claudiobernardoromao/docker-img-deployer/types"
+	"github.com/docker/docker/client"
+)
+
+// Layers are downloaded compressed and then extracted, so an image needs about this many times
+// its download size while it is being pulled
+const imageExtractFactor = 3
+
+// getFreeSpace returns the bytes available to unprivileged users on the file system holding
+// path, or its nearest existing parent, along with the device of that file system
+func getFreeSpace(path string) (free int64, dev uint64, err error) {
+	for {
+		var fi os.FileInfo
+		fi, err = os.Stat(path)
+		if err == nil {
+			if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+				dev = uint64(st.Dev)
+			}
+			break
+		}
+		parent := filepath.Dir(path)
+		if !os.IsNotExist(err) || parent == path {
+			return 0, 0, err
+		}
+		path = parent
+	}
+	var fs syscall.Statfs_t
+	err = syscall.Statfs(path, &fs)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int64(fs.Bavail) * int64(fs.Bsize), dev, nil
+}
+
+// checkFreeSpace aborts if the file system holding path cannot take needed bytes and still
+// keep the reserve configured by operators
+func checkFreeSpace(i *t.Instance, path string, needed int64, what string) error {
+	free, _, err := getFreeSpace(path)
+	if err != nil {
+		return fmt.Errorf("ABORT: Unable to check free space on %s: %s", path, err)
+	}
+	return checkSpace(path, free, needed, getDiskReserve(i), what)
+}
+
+// getDiskReserve returns the bytes operators want left free after a pull or copy
+func getDiskReserve(i *t.Instance) int64 {
+	return int64(i.GetPropInt(t.PropDockerDiskReserveMB)) * 1024 * 1024
+}
+
+// checkSpace aborts if free bytes on the file system holding path cannot cover needed bytes
+// plus the reserve
+func checkSpace(path string, free, needed, reserve int64, what string) error {
+	if free < needed+reserve {
+		return fmt.Errorf("ABORT: Not enough disk space on %s for %s: %s needed plus a reserve of %s, %s available",
+			path, what, formatBytes(needed), formatBytes(reserve), formatBytes(free))
+	}
+	log.Printf("Disk space on %s: %s available, %s needed for %s\n", path, formatBytes(free), formatBytes(needed), what)
+	return nil
+}
+
+// checkPullSpace checks the Docker data root has room for ref before it is pulled. When the
+// registry cannot tell the image size only the reserve is checked.
+func checkPullSpace(cli *client.Client, i *t.Instance, ref string) error {
+	info, err := cli.Info(ctx)
+	if err != nil {
+		return err
+	}
+	size, err := getImageDownloadSize(i, ref)
+	if err != nil {
+		log.Printf("Unable to estimate the size of %s: %s\n", ref, err)
+	}
+	return checkFreeSpace(i, info.DockerRootDir, size*imageExtractFactor, "pulling "+ref)
+}
+
+// checkBindSpace checks the bind roots have room for the archive content copied into binds,
+// adding up the binds that share a file system
+func checkBindSpace(i *t.Instance, binds []bindMount) error {
+	needed := map[uint64]int64{}
+	paths := map[uint64]string{}
+	for _, bind := range binds {
+		if bind.SourceDir == "" {
+			continue
+		}
+		size, err := dirSize(bind.SourceDir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		_, dev, err := getFreeSpace(bind.HostPath)
+		if err != nil {
+			return fmt.Errorf("ABORT: Unable to check free space on %s: %s", bind.HostPath, err)
+		}
+		needed[dev] += size
+		if paths[dev] == "" {
+			paths[dev] = bind.HostPath
+		}
+	}
+	for dev, size := range needed {
+		err := checkFreeSpace(i, paths[dev], size, "archive content copied into binds")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dirSize adds up the sizes of the regular files under dir
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size, err
+}
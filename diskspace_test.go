@@ -0,0 +1,126 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	dt "github.com/claudiobernardoromao/docker-img-deployer/types"
+)
+
+func TestGetDiskReserve(t *testing.T) {
+	tests := []struct {
+		value []string
+		want  int64
+	}{
+		{nil, 1024 * 1024 * 1024},
+		{[]string{"0"}, 0},
+		{[]string{"10"}, 10 * 1024 * 1024},
+		{[]string{"4096"}, 4 * 1024 * 1024 * 1024},
+		{[]string{""}, 1024 * 1024 * 1024},
+		{[]string{"lots"}, 1024 * 1024 * 1024},
+	}
+	for _, test := range tests {
+		i := &dt.Instance{}
+		if test.value != nil {
+			i.Workload.CustomProps = []dt.CustomProp{{Name: dt.PropDockerDiskReserveMB, Values: test.value}}
+		}
+		if got := getDiskReserve(i); got != test.want {
+			t.Errorf("getDiskReserve(%q) = %d, want %d", test.value, got, test.want)
+		}
+	}
+}
+
+func TestCheckSpace(t *testing.T) {
+	const mb = 1024 * 1024
+	tests := []struct {
+		name                  string
+		free, needed, reserve int64
+		ok                    bool
+	}{
+		{"room to spare", 100 * mb, 10 * mb, 10 * mb, true},
+		{"exactly enough", 20 * mb, 10 * mb, 10 * mb, true},
+		{"one byte short", 20*mb - 1, 10 * mb, 10 * mb, false},
+		{"reserve alone too large", 5 * mb, 0, 10 * mb, false},
+		{"unknown size checks the reserve", 10 * mb, 0, 10 * mb, true},
+		{"no reserve", 10 * mb, 10 * mb, 0, true},
+		{"pull needs extraction room", 25 * mb, 10 * mb * imageExtractFactor, 0, false},
+		{"pull with extraction room", 30 * mb, 10 * mb * imageExtractFactor, 0, true},
+		{"nothing free", 0, 1, 0, false},
+	}
+	for _, test := range tests {
+		err := checkSpace("/var/lib/docker", test.free, test.needed, test.reserve, "test")
+		if (err == nil) != test.ok {
+			t.Errorf("%s: checkSpace(%d, %d, %d) = %v, want ok %v", test.name, test.free, test.needed, test.reserve, err, test.ok)
+		}
+	}
+}
+
+func TestCheckBindSpace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskspace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source := filepath.Join(dir, "source")
+	if err := os.MkdirAll(filepath.Join(source, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, size := range map[string]int{"a": 1000, "sub/b": 24} {
+		if err := ioutil.WriteFile(filepath.Join(source, name), make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if size, err := dirSize(source); err != nil || size != 1024 {
+		t.Errorf("dirSize() = %d, %v, want 1024", size, err)
+	}
+	free, _, err := getFreeSpace(filepath.Join(dir, "binds/missing/dir"))
+	if err != nil || free <= 0 {
+		t.Fatalf("getFreeSpace() of a missing directory = %d, %v", free, err)
+	}
+
+	binds := []bindMount{
+		{HostPath: filepath.Join(dir, "binds/one"), SourceDir: source},
+		{HostPath: filepath.Join(dir, "binds/two"), SourceDir: filepath.Join(dir, "missing")},
+		{HostPath: filepath.Join(dir, "binds/three")},
+	}
+	tests := []struct {
+		reserve string
+		ok      bool
+	}{
+		{"0", true},
+		{"1", true},
+		// More than any file system the test runs on holds
+		{"1099511627776", false},
+	}
+	for _, test := range tests {
+		i := &dt.Instance{}
+		i.Workload.CustomProps = []dt.CustomProp{{Name: dt.PropDockerDiskReserveMB, Values: []string{test.reserve}}}
+		if err := checkBindSpace(i, binds); (err == nil) != test.ok {
+			t.Errorf("reserve %s MiB: checkBindSpace() = %v, want ok %v", test.reserve, err, test.ok)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	t "github.com/claudiobernardoromao/docker-img-deployer/types"
+)
+
+// getContainerEnv returns the container environment as sorted NAME=value entries. Later sources
+// override earlier ones (platform tokens, then the platform environment, then DockerEnv) and
+// variables matching the platform blacklist are dropped.
+func getContainerEnv(i *t.Instance) []string {
+	values := map[string]t.EnvVar{}
+	dropped := []string{}
+	for _, v := range i.GetEnvVars() {
+		if isBlacklistedEnv(i, v.Name) {
+			dropped = append(dropped, v.Name+" ("+v.Source+")")
+			continue
+		}
+		if prev, ok := values[v.Name]; ok && prev.Value != v.Value {
+			log.Printf("WARNING: Environment variable %s from %s overrides the value from %s\n", v.Name, v.Source, prev.Source)
+		}
+		values[v.Name] = v
+	}
+	if len(dropped) > 0 {
+		log.Println("Environment variables dropped by the platform blacklist:", strings.Join(dropped, ", "))
+	}
+
+	env := []string{}
+	for name, v := range values {
+		env = append(env, name+"="+v.Value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// isBlacklistedEnv tells whether name matches an entry of the platform environment variable
+// blacklist. Entries may use shell wildcards, e.g. LD_*.
+func isBlacklistedEnv(i *t.Instance, name string) bool {
+	for _, pattern := range i.Process.EnvironmentVariableBlacklist {
+		if pattern == name {
+			return true
+		}
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	dt "github.com/claudiobernardoromao/docker-img-deployer/types"
+)
+
+func TestGetContainerEnv(t *testing.T) {
+	tests := []struct {
+		tokens    map[string]string
+		platform  [][]string
+		docker    []string
+		blacklist []string
+		want      []string
+	}{
+		{nil, nil, nil, nil, []string{}},
+		{
+			tokens:   map[string]string{"PORT": "1", "HOST": "h"},
+			platform: [][]string{{"PATH", "/bin"}, {"PORT", "2"}},
+			docker:   []string{"PORT=3", "MODE=prod"},
+			want:     []string{"HOST=h", "MODE=prod", "PATH=/bin", "PORT=3"},
+		},
+		{
+			tokens:   map[string]string{"PORT": "1"},
+			platform: [][]string{{"PORT", "2"}},
+			want:     []string{"PORT=2"},
+		},
+		{
+			platform: [][]string{{"OPTS", "a=b", "c"}, {}, {"EMPTY"}},
+			docker:   []string{"URL=http://x/?a=b", "NOVALUE", "BLANK="},
+			want:     []string{"BLANK=", "EMPTY=", "OPTS=a=b=c", "URL=http://x/?a=b"},
+		},
+		{
+			tokens: map[string]string{"PORT": "8080"},
+			docker: []string{"LISTEN=:${PORT}", "OTHER=${MISSING}"},
+			want:   []string{"LISTEN=:8080", "OTHER=${MISSING}", "PORT=8080"},
+		},
+		{
+			tokens:    map[string]string{"SECRET_KEY": "s", "PORT": "1"},
+			platform:  [][]string{{"LD_PRELOAD", "x.so"}, {"LD_LIBRARY_PATH", "/lib"}, {"PATH", "/bin"}},
+			docker:    []string{"SECRET_KEY=override", "LD_DEBUG=all", "LDX=1"},
+			blacklist: []string{"SECRET_KEY", "LD_*", "[invalid"},
+			want:      []string{"LDX=1", "PATH=/bin", "PORT=1"},
+		},
+		{
+			docker:    []string{"[invalid=1"},
+			blacklist: []string{"[invalid"},
+			want:      []string{},
+		},
+	}
+	for n, test := range tests {
+		i := &dt.Instance{}
+		i.Token.Tokens = test.tokens
+		i.Process.EnvironmentVariables = test.platform
+		i.Process.EnvironmentVariableBlacklist = test.blacklist
+		if test.docker != nil {
+			i.Workload.CustomProps = []dt.CustomProp{{Name: dt.PropDockerEnv, Values: test.docker}}
+		}
+		if got := getContainerEnv(i); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("test %d: getContainerEnv() = %q, want %q", n, got, test.want)
+		}
+	}
+}
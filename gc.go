@@ -0,0 +1,322 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/context"
+
+	t "github.com/claudiobernardoromao/docker-img-deployer/types"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// Directory holding the deployer's records about this host
+const hostStateDir = "/var/lib/apprenda-docker-deployer"
+
+// File under hostStateDir listing the images obtained by the deployer, keyed by image ID
+const trackedImagesFileName = "images.json"
+
+// Label marking containers created by the deployer
+const labelManaged = "com.apprenda.deployer.managed"
+
+// Label recording the image reference a container was created from
+const labelImage = "com.apprenda.deployer.image"
+
+// Maximum time to wait for another deployer process updating the tracked images
+const trackedImagesLockTimeout = 5 * time.Minute
+
+type gcOptions struct {
+	// Images an undeployed container was using
+	Released []string
+	// Remove the released images without waiting for the grace period
+	RemoveReleased bool
+	DryRun         bool
+}
+
+func getTrackedImagesPath() string {
+	return filepath.Join(hostStateDir, trackedImagesFileName)
+}
+
+// lockTrackedImages loads the tracked images under an exclusive lock. The caller must unlock.
+func lockTrackedImages() (map[string]*t.TrackedImage, func(), error) {
+	err := os.MkdirAll(hostStateDir, 0755)
+	if err != nil {
+		return nil, nil, err
+	}
+	lockCtx, cancel := context.WithTimeout(ctx, trackedImagesLockTimeout)
+	defer cancel()
+	unlock, _, err := lockFile(lockCtx, getTrackedImagesPath()+".lock", "Another deployer process is updating the tracked images")
+	if err != nil {
+		return nil, nil, err
+	}
+	tracked := map[string]*t.TrackedImage{}
+	_, err = readJSONFileIfExists(getTrackedImagesPath(), &tracked)
+	if err != nil {
+		unlock()
+		return nil, nil, err
+	}
+	return tracked, unlock, nil
+}
+
+func saveTrackedImages(tracked map[string]*t.TrackedImage) error {
+	b, err := json.MarshalIndent(tracked, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(getTrackedImagesPath(), b, 0644)
+}
+
+// getLocalImageIDs returns the IDs of the images on the host, to tell which images a pull, load
+// or build brings in. It returns nil if they cannot be listed.
+func getLocalImageIDs(cli *client.Client) map[string]bool {
+	images, err := cli.ImageList(ctx, types.ImageListOptions{All: true})
+	if err != nil {
+		log.Println("Unable to list images, new images will not be garbage collected:", err)
+		return nil
+	}
+	ids := map[string]bool{}
+	for _, img := range images {
+		ids[img.ID] = true
+	}
+	return ids
+}
+
+// trackImage records that the deployer obtained ref, making its image eligible for garbage collection.
+// Images in existing, the images on the host before ref was obtained, are only tracked if the deployer
+// obtained them earlier, and nothing is tracked if existing is nil. Failures are only logged since they
+// must not fail the deployment.
+func trackImage(cli *client.Client, ref string, existing map[string]bool) {
+	if existing == nil {
+		return
+	}
+	img, _, err := cli.ImageInspectWithRaw(ctx, ref)
+	if err == nil {
+		var unlock func()
+		var tracked map[string]*t.TrackedImage
+		tracked, unlock, err = lockTrackedImages()
+		if err == nil {
+			defer unlock()
+			now := time.Now().UTC()
+			ti := tracked[img.ID]
+			if ti == nil && existing[img.ID] {
+				log.Printf("Image %s was already on the host, leaving it out of garbage collection\n", ref)
+				return
+			}
+			if ti == nil {
+				ti = &t.TrackedImage{AcquiredAt: now}
+				tracked[img.ID] = ti
+			}
+			ti.LastUsed = now
+			if !containsFold(ti.Refs, ref) {
+				ti.Refs = append(ti.Refs, ref)
+			}
+			err = saveTrackedImages(tracked)
+		}
+	}
+	if err != nil {
+		log.Printf("Unable to track image %s for garbage collection: %s\n", ref, err)
+	}
+}
+
+// imageGC removes images obtained by the deployer that no container uses, once they have been
+// unused for the grace period, or least recently used first while the Docker disk is too full.
+// Images the deployer did not obtain are never removed.
+func imageGC(cli *client.Client, i *t.Instance, opts gcOptions) error {
+	tracked, unlock, err := lockTrackedImages()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{All: true})
+	if err != nil {
+		return err
+	}
+	managedUsers := map[string]int{}
+	inUse := map[string]bool{}
+	for _, c := range containers {
+		inUse[c.ImageID] = true
+		if c.Labels[labelManaged] == "true" {
+			managedUsers[c.ImageID]++
+		}
+	}
+
+	now := time.Now().UTC()
+	grace := i.GetPropDuration(t.PropDockerImageGCGraceSecs)
+	var unused []string
+	for id, ti := range tracked {
+		if _, _, err := cli.ImageInspectWithRaw(ctx, id); client.IsErrImageNotFound(err) {
+			log.Printf("Image %s was removed outside of the deployer, no longer tracking it\n", id)
+			delete(tracked, id)
+			continue
+		}
+		if inUse[id] {
+			log.Printf("Image %s (%v) is used by %d deployer containers\n", id, ti.Refs, managedUsers[id])
+			ti.LastUsed = now
+			continue
+		}
+		if containsFold(opts.Released, id) {
+			if !opts.RemoveReleased {
+				ti.LastUsed = now
+				continue
+			}
+			ti.LastUsed = time.Time{}
+		}
+		unused = append(unused, id)
+	}
+	sort.Slice(unused, func(a, b int) bool {
+		return tracked[unused[a]].LastUsed.Before(tracked[unused[b]].LastUsed)
+	})
+
+	threshold := i.GetPropInt(t.PropDockerImageGCDiskThreshold)
+	for _, id := range unused {
+		ti := tracked[id]
+		reason := ""
+		if now.Sub(ti.LastUsed) >= grace {
+			reason = fmt.Sprintf("unused since %s", ti.LastUsed.Format(time.RFC3339))
+		} else if threshold > 0 {
+			usage, err := getDockerDiskUsage(cli)
+			if err != nil {
+				log.Println("Unable to check Docker disk usage:", err)
+			} else if usage > threshold {
+				reason = fmt.Sprintf("Docker disk is %d%% full", usage)
+			}
+		}
+		if reason == "" {
+			continue
+		}
+		if opts.DryRun {
+			log.Printf("Would remove image %s (%v): %s\n", id, ti.Refs, reason)
+			continue
+		}
+		log.Printf("Removing image %s (%v): %s\n", id, ti.Refs, reason)
+		if removeTrackedImage(cli, id, ti) {
+			delete(tracked, id)
+		}
+	}
+
+	if opts.DryRun {
+		return nil
+	}
+	return saveTrackedImages(tracked)
+}
+
+// removeTrackedImage removes the references the deployer created that still refer to the image,
+// and the image itself if nothing else refers to it. References moved to another image since are
+// left alone and dropped from ti. It reports whether the image is gone.
+func removeTrackedImage(cli *client.Client, id string, ti *t.TrackedImage) bool {
+	options := types.ImageRemoveOptions{
+		PruneChildren: true,
+	}
+	refs := []string{}
+	for _, ref := range ti.Refs {
+		img, _, err := cli.ImageInspectWithRaw(ctx, ref)
+		if client.IsErrImageNotFound(err) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Image reference %s not removed: %s\n", ref, err)
+			refs = append(refs, ref)
+			continue
+		}
+		if img.ID != id {
+			log.Printf("Image reference %s now refers to %s, leaving it\n", ref, img.ID)
+			continue
+		}
+		_, err = cli.ImageRemove(ctx, ref, options)
+		if err != nil && !client.IsErrImageNotFound(err) {
+			log.Printf("Image reference %s not removed: %s\n", ref, err)
+			refs = append(refs, ref)
+		}
+	}
+	ti.Refs = refs
+	img, _, err := cli.ImageInspectWithRaw(ctx, id)
+	if client.IsErrImageNotFound(err) {
+		log.Println("Image removed:", id)
+		return true
+	}
+	if err != nil {
+		log.Printf("Unable to inspect image %s: %s\n", id, err)
+		return false
+	}
+	if len(img.RepoTags) > 0 {
+		log.Printf("Image %s is still tagged %v outside of the deployer, leaving it\n", id, img.RepoTags)
+		return true
+	}
+	_, err = cli.ImageRemove(ctx, id, options)
+	if err != nil {
+		log.Printf("Image %s not removed: %s\n", id, err)
+		return false
+	}
+	log.Println("Image removed:", id)
+	return true
+}
+
+// getDockerDiskUsage returns how full the file system holding the Docker root directory is, in percent
+func getDockerDiskUsage(cli *client.Client) (int, error) {
+	info, err := cli.Info(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var fs syscall.Statfs_t
+	err = syscall.Statfs(info.DockerRootDir, &fs)
+	if err != nil {
+		return 0, err
+	}
+	if fs.Blocks == 0 {
+		return 0, nil
+	}
+	return int((fs.Blocks - fs.Bfree) * 100 / fs.Blocks), nil
+}
+
+// runImageGC implements the gc command. It can run without an instance, e.g. from cron, in which
+// case the default grace period and disk threshold apply.
+func runImageGC(args []string) error {
+	flags := flag.NewFlagSet("gc", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only log the images that would be removed")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	i, err := getInstance()
+	if err != nil {
+		i = &t.Instance{}
+	}
+	cli, err := client.NewEnvClient()
+	if err != nil {
+		return err
+	}
+	return imageGC(cli, i, gcOptions{DryRun: *dryRun})
+}
@@ -0,0 +1,194 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	dt "github.com/claudiobernardoromao/docker-img-deployer/types"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// fakeDocker serves the image endpoints of the Docker API used by the deployer from an in-memory
+// set of images and tags
+type fakeDocker struct {
+	sync.Mutex
+	images map[string]bool   // image IDs
+	tags   map[string]string // reference to image ID
+	server *httptest.Server
+}
+
+// newFakeDocker starts a fake Docker daemon and returns it with a client connected to it
+func newFakeDocker(t *testing.T, tags map[string]string, ids ...string) (*fakeDocker, *client.Client) {
+	d := &fakeDocker{images: map[string]bool{}, tags: map[string]string{}}
+	for _, id := range ids {
+		d.images[id] = true
+	}
+	for ref, id := range tags {
+		d.images[id] = true
+		d.tags[ref] = id
+	}
+	d.server = httptest.NewServer(d)
+	cli, err := client.NewClient("tcp://"+strings.TrimPrefix(d.server.URL, "http://"), "1.25", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, cli
+}
+
+// resolve returns the image ID a reference or ID refers to
+func (d *fakeDocker) resolve(name string) (string, bool) {
+	if d.images[name] {
+		return name, true
+	}
+	id, ok := d.tags[name]
+	return id, ok
+}
+
+// repoTags returns the sorted references to an image
+func (d *fakeDocker) repoTags(id string) []string {
+	tags := []string{}
+	for ref, refID := range d.tags {
+		if refID == id {
+			tags = append(tags, ref)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+func (d *fakeDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.Lock()
+	defer d.Unlock()
+	p := r.URL.Path
+	if n := strings.Index(p[1:], "/"); strings.HasPrefix(p, "/v") && n != -1 {
+		p = p[n+1:]
+	}
+	if !strings.HasPrefix(p, "/images/") {
+		http.NotFound(w, r)
+		return
+	}
+	name := strings.TrimPrefix(p, "/images/")
+	switch {
+	case r.Method == "GET" && strings.HasSuffix(name, "/json"):
+		id, ok := d.resolve(strings.TrimSuffix(name, "/json"))
+		if !ok {
+			http.Error(w, `{"message":"No such image"}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(types.ImageInspect{ID: id, RepoTags: d.repoTags(id)})
+	case r.Method == "DELETE":
+		if id, ok := d.tags[name]; ok {
+			delete(d.tags, name)
+			json.NewEncoder(w).Encode([]types.ImageDelete{{Untagged: name + " (" + id + ")"}})
+			return
+		}
+		if !d.images[name] {
+			http.Error(w, `{"message":"No such image"}`, http.StatusNotFound)
+			return
+		}
+		if len(d.repoTags(name)) > 0 {
+			http.Error(w, `{"message":"image is referenced in multiple repositories"}`, http.StatusConflict)
+			return
+		}
+		delete(d.images, name)
+		json.NewEncoder(w).Encode([]types.ImageDelete{{Deleted: name}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestRemoveTrackedImage(t *testing.T) {
+	const old, newer = "sha256:old", "sha256:new"
+	tests := []struct {
+		name     string
+		tags     map[string]string
+		refs     []string
+		gone     bool
+		leftRefs []string
+		tags2    map[string]string
+		images   []string
+	}{
+		{
+			name: "ref still on the image",
+			tags: map[string]string{"app:1": old},
+			refs: []string{"app:1"},
+			gone: true, leftRefs: []string{},
+			tags2: map[string]string{}, images: []string{newer},
+		},
+		{
+			name: "ref moved to a newer image",
+			tags: map[string]string{"app:1": newer},
+			refs: []string{"app:1"},
+			gone: true, leftRefs: []string{},
+			tags2: map[string]string{"app:1": newer}, images: []string{newer},
+		},
+		{
+			name: "one ref moved, the other still on the image",
+			tags: map[string]string{"app:1": newer, "mirror/app:1": old},
+			refs: []string{"app:1", "mirror/app:1"},
+			gone: true, leftRefs: []string{},
+			tags2: map[string]string{"app:1": newer}, images: []string{newer},
+		},
+		{
+			name: "ref already removed",
+			tags: map[string]string{},
+			refs: []string{"app:1"},
+			gone: true, leftRefs: []string{},
+			tags2: map[string]string{}, images: []string{newer},
+		},
+		{
+			name: "image also tagged by an operator",
+			tags: map[string]string{"app:1": old, "ops/keep:1": old},
+			refs: []string{"app:1"},
+			gone: true, leftRefs: []string{},
+			tags2: map[string]string{"ops/keep:1": old}, images: []string{newer, old},
+		},
+	}
+	for _, test := range tests {
+		d, cli := newFakeDocker(t, test.tags, old, newer)
+		ti := &dt.TrackedImage{Refs: test.refs}
+		gone := removeTrackedImage(cli, old, ti)
+		d.server.Close()
+
+		images := []string{}
+		for id := range d.images {
+			images = append(images, id)
+		}
+		sort.Strings(images)
+		if gone != test.gone || !reflect.DeepEqual(ti.Refs, test.leftRefs) {
+			t.Errorf("%s: removeTrackedImage() = %v with refs %q, want %v with %q", test.name, gone, ti.Refs, test.gone, test.leftRefs)
+		}
+		if !reflect.DeepEqual(d.tags, test.tags2) || !reflect.DeepEqual(images, test.images) {
+			t.Errorf("%s: left tags %v and images %v, want %v and %v", test.name, d.tags, images, test.tags2, test.images)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	t "github.com/claudiobernardoromao/docker-img-deployer/types"
+	"github.com/docker/docker/client"
+)
+
+// Maximum time to wait for another deployer process loading the same archive
+const imageLoadLockTimeout = 30 * time.Minute
+
+// getImageArchivePath returns the docker save tarball shipped with the component, or an empty
+// string if images must be pulled from a registry
+func getImageArchivePath(i *t.Instance) (string, error) {
+	name := i.GetPropString(t.PropDockerImageArchive)
+	if name == "" {
+		return "", nil
+	}
+	archiveSrcDir := getArchiveSrcDir(i)
+	archivePath := filepath.Join(archiveSrcDir, name)
+	if rel, err := filepath.Rel(archiveSrcDir, archivePath); err != nil || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("ABORT: DockerImageArchive %s must be inside the component folder of the archive", name)
+	}
+	return archivePath, nil
+}
+
+// imageLoad loads the docker save tarball at archivePath and verifies it provides ref.
+// Archives already loaded on this host are skipped as long as ref still refers to the same image.
+func imageLoad(cli *client.Client, i *t.Instance, archivePath, ref string) error {
+	sum, err := fileSHA256(archivePath)
+	if err != nil {
+		return fmt.Errorf("ABORT: Unable to read image archive %s: %s", archivePath, err)
+	}
+
+	err = os.MkdirAll(hostStateDir, 0755)
+	if err != nil {
+		return err
+	}
+	markerPath := filepath.Join(hostStateDir, "loaded-"+sum)
+
+	lockCtx, cancel := context.WithTimeout(ctx, imageLoadLockTimeout)
+	defer cancel()
+	unlock, _, err := lockFile(lockCtx, markerPath+".lock", "Another deployer process is loading "+archivePath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if b, err := ioutil.ReadFile(markerPath); err == nil {
+		img, _, err := cli.ImageInspectWithRaw(ctx, ref)
+		if err == nil && img.ID == strings.TrimSpace(string(b)) {
+			log.Printf("Image archive %s was already loaded as %s, skipping\n", archivePath, img.ID)
+			return nil
+		}
+	}
+
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	info, err := cli.Info(ctx)
+	if err != nil {
+		return err
+	}
+	// The tarball holds uncompressed layers, which the daemon copies as it extracts them
+	err = checkFreeSpace(i, info.DockerRootDir, fi.Size(), "loading "+archivePath)
+	if err != nil {
+		return err
+	}
+
+	// The image ID is known before loading, so that an older image tagged as ref on the host
+	// cannot pass for the content of the tarball
+	imageID, err := getArchiveImageID(f, ref)
+	if err != nil {
+		return fmt.Errorf("ABORT: Image archive %s: %s", archivePath, err)
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	existing := getLocalImageIDs(cli)
+	log.Println("Loading image archive", archivePath)
+	resp, err := cli.ImageLoad(ctx, f, true)
+	if err != nil {
+		return fmt.Errorf("ABORT: Unable to load image archive %s: %s", archivePath, err)
+	}
+	defer resp.Body.Close()
+	if resp.JSON {
+		_, err = readProgress(resp.Body, "Loading "+archivePath)
+	} else {
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+	}
+	if err != nil {
+		return fmt.Errorf("ABORT: Unable to load image archive %s: %s", archivePath, err)
+	}
+
+	img, _, err := cli.ImageInspectWithRaw(ctx, ref)
+	if client.IsErrImageNotFound(err) {
+		return fmt.Errorf("ABORT: Image archive %s does not contain %s", archivePath, ref)
+	}
+	if err != nil {
+		return err
+	}
+	if img.ID != imageID {
+		return fmt.Errorf("ABORT: Image archive %s was loaded but %s is %s instead of %s from the archive", archivePath, ref, img.ID, imageID)
+	}
+	log.Printf("Loaded %s as %s\n", ref, img.ID)
+	trackImage(cli, ref, existing)
+
+	return ioutil.WriteFile(markerPath, []byte(img.ID+"\n"), 0644)
+}
+
+// archiveManifestEntry is an image of the manifest.json of a docker save tarball
+type archiveManifestEntry struct {
+	Config   string
+	RepoTags []string
+}
+
+// getArchiveImageID returns the ID of the image that provides ref in a docker save tarball, read
+// from its manifest.json. Loading a tarball applies every tag it carries, so it must hold exactly
+// one image tagged as ref and nothing else, or it could retag other images on the host. A digest
+// ref must be the image ID itself, untagged, since tarballs hold no registry digests.
+func getArchiveImageID(r io.Reader, ref string) (string, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return "", err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+
+	var manifest []archiveManifestEntry
+	found := false
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if path.Clean(hdr.Name) != "manifest.json" {
+			continue
+		}
+		// The daemon would use the last copy, which need not be the one checked
+		if found {
+			return "", fmt.Errorf("more than one manifest.json")
+		}
+		found = true
+		err = json.NewDecoder(tr).Decode(&manifest)
+		if err != nil {
+			return "", fmt.Errorf("invalid manifest.json: %s", err)
+		}
+	}
+	if !found {
+		return "", fmt.Errorf("no manifest.json, it must be saved with Docker 1.10 or later")
+	}
+	if len(manifest) != 1 {
+		return "", fmt.Errorf("it holds %d images, it must hold only %s", len(manifest), ref)
+	}
+
+	entry := manifest[0]
+	id := "sha256:" + strings.TrimSuffix(path.Base(entry.Config), ".json")
+	if isDigestRef(ref) {
+		if id != ref[strings.Index(ref, "@")+1:] {
+			return "", fmt.Errorf("it does not contain %s", ref)
+		}
+		if len(entry.RepoTags) > 0 {
+			return "", fmt.Errorf("it tags %s, it must not tag anything for %s", strings.Join(entry.RepoTags, ", "), ref)
+		}
+		return id, nil
+	}
+	if len(entry.RepoTags) == 0 {
+		return "", fmt.Errorf("it does not contain %s", ref)
+	}
+	for _, tag := range entry.RepoTags {
+		if !sameImageRef(tag, ref) {
+			return "", fmt.Errorf("it also tags %s, it must only tag %s", tag, ref)
+		}
+	}
+	return id, nil
+}
+
+// sameImageRef tells whether two tagged references name the same image, once defaults apply
+func sameImageRef(a, b string) bool {
+	aRegistry, aRepo, aTag, _ := parseImageRef(a)
+	bRegistry, bRepo, bTag, _ := parseImageRef(b)
+	if aTag == "" {
+		aTag = "latest"
+	}
+	if bTag == "" {
+		bTag = "latest"
+	}
+	return aRegistry == bRegistry && aRepo == bRepo && aTag == bTag
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
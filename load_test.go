@@ -0,0 +1,104 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"testing"
+)
+
+// makeSaveTarball returns a tarball holding the given files, like docker save writes
+func makeSaveTarball(t *testing.T, files map[string]string, compress bool) []byte {
+	var b bytes.Buffer
+	var gz *gzip.Writer
+	tw := tar.NewWriter(&b)
+	if compress {
+		gz = gzip.NewWriter(&b)
+		tw = tar.NewWriter(gz)
+	}
+	for name, content := range files {
+		err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))})
+		if err == nil {
+			_, err = tw.Write([]byte(content))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return b.Bytes()
+}
+
+func TestGetArchiveImageID(t *testing.T) {
+	single := map[string]string{"manifest.json": `[{"Config":"aaaa.json","RepoTags":["nginx:1.11","docker.io/library/nginx:1.11"],"Layers":["l1/layer.tar"]}]`, "aaaa.json": "{}"}
+	untagged := map[string]string{"manifest.json": `[{"Config":"bbbb.json","RepoTags":null,"Layers":["l2/layer.tar"]}]`}
+	latest := map[string]string{"manifest.json": `[{"Config":"cccc.json","RepoTags":["busybox:latest"]}]`}
+	extraTag := map[string]string{"manifest.json": `[{"Config":"aaaa.json","RepoTags":["nginx:1.11","nginx:latest"]}]`}
+	otherTenant := map[string]string{"manifest.json": `[{"Config":"aaaa.json","RepoTags":["nginx:1.11"]},{"Config":"dddd.json","RepoTags":["apprenda-build/other/app/web:v1"]}]`}
+	twoManifests := map[string]string{"manifest.json": `[{"Config":"aaaa.json","RepoTags":["nginx:1.11"]}]`, "./manifest.json": `[{"Config":"dddd.json","RepoTags":["nginx:latest"]}]`}
+	legacy := map[string]string{"repositories": `{"nginx":{"1.11":"cccc"}}`}
+
+	tests := []struct {
+		files    map[string]string
+		compress bool
+		ref      string
+		id       string
+	}{
+		{single, false, "nginx:1.11", "sha256:aaaa"},
+		{single, true, "docker.io/library/nginx:1.11", "sha256:aaaa"},
+		{latest, false, "busybox", "sha256:cccc"},
+		{untagged, false, "nginx@sha256:bbbb", "sha256:bbbb"},
+		{single, false, "nginx@sha256:aaaa", ""},
+		{untagged, false, "nginx@sha256:cccc", ""},
+		{untagged, false, "nginx:1.11", ""},
+		{single, false, "nginx:latest", ""},
+		{single, false, "other/nginx:1.11", ""},
+		{extraTag, false, "nginx:1.11", ""},
+		{otherTenant, false, "nginx:1.11", ""},
+		{twoManifests, false, "nginx:1.11", ""},
+		{legacy, false, "nginx:1.11", ""},
+		{map[string]string{"manifest.json": "{"}, false, "nginx:1.11", ""},
+		{map[string]string{"manifest.json": "[]"}, false, "nginx:1.11", ""},
+	}
+	for _, test := range tests {
+		id, err := getArchiveImageID(bytes.NewReader(makeSaveTarball(t, test.files, test.compress)), test.ref)
+		if test.id == "" {
+			if err == nil {
+				t.Errorf("getArchiveImageID(%s) with %v = %s, want an error", test.ref, test.files, id)
+			}
+			continue
+		}
+		if err != nil || id != test.id {
+			t.Errorf("getArchiveImageID(%s) with %v = %s, %v, want %s", test.ref, test.files, id, err, test.id)
+		}
+	}
+}
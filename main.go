@@ -28,7 +28,6 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -45,8 +44,6 @@ import (
 
 	t "github.com/claudiobernardoromao/docker-img-deployer/types"
 	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
 	"github.com/docker/go-connections/nat"
 )
@@ -54,33 +51,10 @@ import (
 const version = "1.0.0"
 const instanceJSONFileName = "instance.json"
 const dockerMarkerFileName = "apprenda-docker.properties"
-const defaultBindsDirShared = "/apprenda/docker-binds"
-const defaultBindsDirPermissions = 0777
-
-// ACP Custom Property names
-const propDockerImageName = "DockerImageName"
-const propDockerImageTag = "DockerImageTag"
-const propDockerCmd = "DockerCmd"
-const propDockerEntrypoint = "DockerEntrypoint"
-const propDockerBindHost = "DockerBindHost"
-const propDockerBindLocal = "DockerBindLocal"
-const propDockerBindShared = "DockerBindShared"
-const propDockerBindSharedRootDir = "DockerBindSharedRootDir"
-const propDockerBindDirPermissions = "DockerBindDirPermissions"
-const propDockerBindHostApprovedDirs = "DockerBindHostApprovedDirs"
-const propDockerNetwork = "DockerNetwork"
-const propDockerNetworkScope = "DockerNetworkScope"
-const propDockerHealthCheck = "DockerHealthCheck"
-const propDockerHealthCheckPath = "DockerHealthCheckPath"
-const propDockerHealthCheckScheme = "DockerHealthCheckScheme"
-const propDockerHealthCheckTimeoutSecs = "DockerHealthCheckTimeoutSecs"
-const propDockerReadinessCheck = "DockerReadinessCheck"
-const propDockerReadinessCheckPath = "DockerReadinessCheckPath"
-const propDockerReadinessCheckScheme = "DockerReadinessCheckScheme"
-const propDockerReadinessCheckTimeoutSecs = "DockerReadinessCheckTimeoutSecs"
-const propDockerForcePull = "DockerForcePull"
-const propDockerImageRemove = "DockerImageRemove"
-const propDockerRemoveImage = "DockerRemoveImage"
+const readinessResultFileName = "readiness.json"
+const logForwarderPidFileName = "logstash_forwarder.pid"
+
+//go:generate go run tools/propdocs/main.go -readme README.md
 
 var ctx = context.Background()
 
@@ -90,17 +64,57 @@ var ctx = context.Background()
 // It assumes the presence of an instance.json file in the directory above PWD
 func main() {
 
-	logTo("init.out")
+	flag.Parse()
+	command, args := flag.Arg(0), []string{}
+	if flag.NArg() > 1 {
+		args = flag.Args()[1:]
+	}
+
+	// deploy -dry-run is an alias of plan
+	if command == "deploy" && len(args) > 0 && (args[0] == "-dry-run" || args[0] == "--dry-run") {
+		command, args = "plan", args[1:]
+	}
+
+	// Planning and validation must not leave anything behind, so log to stderr instead of a file.
+	// Status is polled while the instance runs, so it logs to its own file to keep init.out intact.
+	if command == "plan" || command == "validate" || command == "gc" || command == "prefetch" {
+		log.SetOutput(os.Stderr)
+	} else if command == "status" {
+		f := logTo("status.out")
+		defer f.Close()
+	} else {
+		logTo("init.out")
+	}
 	log.Println("Initializing Docker Deployer version: ", version)
 
-	flag.Parse()
+	// validate works offline on any instance.json or DeploymentManifest.xml
+	if command == "validate" {
+		err := validateProperties(args)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	// gc and prefetch are run by operators on a node, with or without an instance
+	if command == "gc" || command == "prefetch" {
+		run := runImageGC
+		if command == "prefetch" {
+			run = runPrefetch
+		}
+		err := run(args)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
 
 	i, err := getInstance()
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	switch flag.Arg(0) {
+	switch command {
 	case "deploy":
 		f := logTo("deployWorkload.out")
 		defer f.Close()
@@ -129,8 +143,18 @@ func main() {
 		if err != nil {
 			log.Fatalln(err)
 		}
+	case "status":
+		err = containerStatus(i, args)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	case "plan":
+		err = containerPlan(i, args)
+		if err != nil {
+			log.Fatalln(err)
+		}
 	default:
-		fmt.Println("Usage: instance [deploy|start|stop|undeploy]")
+		fmt.Println("Usage: instance [deploy [-dry-run]|start|stop|undeploy|status|plan|validate [file]|gc [-dry-run]|prefetch [ref|instance.json]]")
 	}
 
 }
@@ -145,8 +169,12 @@ func logTo(fileName string) *os.File {
 }
 
 func getInstance() (*t.Instance, error) {
+	return loadInstance(filepath.Join("..", instanceJSONFileName))
+}
 
-	f, err := os.Open(filepath.Join("..", instanceJSONFileName))
+func loadInstance(path string) (*t.Instance, error) {
+
+	f, err := os.Open(path)
 	defer f.Close()
 	if err != nil {
 		return nil, err
@@ -172,160 +200,157 @@ func containerCreate(i *t.Instance) error {
 		return err
 	}
 
-	repo := i.GetPropFirstValue(propDockerImageName)
-	if repo == "" {
-		return errors.New("ABORT: DockerImageName Custom Property for the component must be populated with a valid Registry name")
-	}
-	tag := i.GetPropFirstValue(propDockerImageTag)
-	if tag == "" {
-		tag = "latest"
-	}
-	ref := repo + ":" + tag
-	
-	// Claudio
-	fmt.Println("redefinindo o nome da imagem")
-	ref = "nginx:latest"
-	fmt.Println(ref)
-	fmt.Println("Chamando o image pull antes de executar as alterações no objeto cli")
-	err = imagePull(cli, ref)
-
-	forcePull := strings.ToLower(i.GetPropFirstValue(propDockerForcePull))
-	if forcePull == "yes" {
+	spec, err := buildContainerSpec(i)
+	if err != nil {
+		return err
+	}
+
+	archivePath, err := getImageArchivePath(i)
+	if err != nil {
+		return err
+	}
+	if isDockerfileDeploy(i) {
+		// The base images of the Dockerfile are checked against the image policy before building
+		err = imageBuild(cli, i, spec.Image)
+		if err != nil {
+			return err
+		}
+	} else if err = checkDeployImagePolicy(i, spec.Image); err != nil {
+		return err
+	} else if archivePath != "" {
+		err = imageLoad(cli, i, archivePath, spec.Image)
+		if err != nil {
+			return err
+		}
+	} else if i.GetPropBool(t.PropDockerForcePull) {
 		log.Println("Forcing an image pull")
-		err = imagePull(cli, ref)
+		err = imagePull(cli, i, spec.Image)
 		if err != nil {
 			return err
 		}
 	}
 
-	ports, portBindings, err := parseInstancePorts(i)
+	err = pinImageDigest(cli, i, spec)
 	if err != nil {
 		return err
 	}
+	ref := spec.Image
 
-	env, err := i.GetEnv()
+	// The tag may resolve to a denied digest, so check the image itself before running it
+	localRef, img, err := getLocalImage(cli, i, ref)
+	if client.IsErrImageNotFound(err) {
+		log.Println("Image not found locally, trying to pull it")
+		err = imagePull(cli, i, ref)
+		if err != nil {
+			return err
+		}
+		localRef, img, err = getLocalImage(cli, i, ref)
+	}
 	if err != nil {
 		return err
 	}
-
-	config := &container.Config{
-		Image:        ref,
-		ExposedPorts: ports,
-		Env:          env,
-		AttachStdin:  false,
-		AttachStdout: false,
-		AttachStderr: false,
-		Tty:          false,
-	}
-
-	cmd := i.GetPropFirstValue(propDockerCmd)
-	if cmd != "" {
-		config.Cmd = strings.Fields(cmd)
+	if localRef != ref {
+		log.Printf("Using %s, pulled from the mirror of %s\n", localRef, ref)
+		spec.setImage(localRef)
+		ref = localRef
 	}
-
-	entrypoint := i.GetPropFirstValue(propDockerEntrypoint)
-	if entrypoint != "" {
-		config.Entrypoint = strings.Fields(entrypoint)
+	err = checkImageDigestPolicy(i, ref, img.RepoDigests)
+	if err != nil {
+		return err
 	}
-
-	binds, err := processBinds(i)
+	err = verifyImageSignature(i, ref, img)
 	if err != nil {
 		return err
 	}
 
-	resources := container.Resources{}
-	if i.Resource.ResourcePolicy.MemoryLimit > 0 {
-		resources.Memory = i.Resource.ResourcePolicy.MemoryLimit * 1024 * 1024
-	}
-	if i.Resource.ResourcePolicy.CPULimit > 0 {
-		resources.CPUShares = i.Resource.ResourcePolicy.CPULimit
+	err = prepareBinds(i, spec.Binds)
+	if err != nil {
+		return err
 	}
 
-	networkName := i.GetPropFirstValue(propDockerNetwork)
-	networkingConfig := &network.NetworkingConfig{}
-
-	networkScope := strings.ToLower(i.GetPropFirstValue(propDockerNetworkScope))
-	if networkScope != "" {
-		networkName = getScopedNetworkName(i)
-
-		networkExists, err := networkExists(cli, networkName)
+	if spec.NetworkScope != "" {
+		networkExists, err := networkExists(cli, spec.NetworkName)
 		if err != nil {
 			return err
 		}
 
 		if !networkExists {
-			err = createNetwork(cli, networkName)
+			err = createNetwork(cli, spec.NetworkName)
 			if err != nil {
 				return err
 			}
 		}
-
-		netAlias := i.Workload.BundleName
-		if networkScope != "app" {
-			netAlias = strings.Join([]string{i.Workload.ApplicationAlias, i.Workload.VersionAlias, i.Workload.BundleName}, "-")
-		}
-		networkingConfig = &network.NetworkingConfig{
-			EndpointsConfig: map[string]*network.EndpointSettings{
-				networkName: {
-					Aliases:   []string{netAlias},
-					NetworkID: networkName,
-				},
-			},
-		}
 	}
 
-	hostConfig := &container.HostConfig{
-		Binds:        binds,
-		Resources:    resources,
-		PortBindings: portBindings,
-		NetworkMode:  container.NetworkMode(networkName),
+	// The platform may retry a deployment, so reuse a container left by an earlier attempt if it still matches
+	existingID, err := checkExistingContainer(cli, spec)
+	if err != nil {
+		return err
+	}
+	if existingID != "" {
+		st := newState(cli, i, spec, existingID)
+		return saveState(i, st, "deploy", "Reused container "+existingID+" created from "+ref)
 	}
 
-	_, err = cli.ContainerCreate(ctx, config, hostConfig, networkingConfig, i.ContainerName())
+	created, err := cli.ContainerCreate(ctx, spec.Config, spec.HostConfig, spec.NetworkingConfig, spec.Name)
 	if err != nil {
-		if client.IsErrImageNotFound(err) {
-			log.Println("Image not found locally, trying to pull it")
-			fmt.Printf("chamando agora com o objeto preenchido")
-			err = imagePull(cli, ref)
-			if err != nil {
-				return err
-			}
-			// and try again
-			_, err = cli.ContainerCreate(ctx, config, hostConfig, networkingConfig, i.ContainerName())
-			if err != nil {
-				return err
-			}
-		} else {
-			return err
-		}
+		return err
 	}
 	log.Println("Container created from", ref)
-	return nil
+
+	st := newState(cli, i, spec, created.ID)
+	return saveState(i, st, "deploy", "Created container "+created.ID+" from "+ref)
 }
 
-func imagePull(cli *client.Client, ref string) error {
-	log.Printf("Pulling %q from the registry...\n", ref)
-	fmt.Println("entrando na funcao para baixar a imagem")
-	fmt.Println(ref)
-	resp, err := cli.ImagePull(context.Background(), ref, types.ImagePullOptions{})
+// checkExistingContainer looks for a container already named after the spec. It returns the
+// container ID if it was created from an identical spec, or removes it if it has drifted.
+func checkExistingContainer(cli *client.Client, spec *containerSpec) (string, error) {
+	c, err := cli.ContainerInspect(ctx, spec.Name)
+	if client.IsErrContainerNotFound(err) {
+		return "", nil
+	}
 	if err != nil {
-		fmt.Println("problemas para baixar a imagem")
-		fmt.Println(err)
-		return err
+		return "", err
 	}
-	defer resp.Close()
-	if _, err = io.Copy(ioutil.Discard, resp); err != nil {
-		return err
+
+	drift := ""
+	if c.Config == nil || c.Config.Labels[labelConfigHash] != spec.Config.Labels[labelConfigHash] {
+		drift = "configuration changed"
+	} else if img, _, err := cli.ImageInspectWithRaw(ctx, spec.Image); err == nil && img.ID != c.Image {
+		drift = "image " + spec.Image + " now refers to " + img.ID
 	}
-	log.Println("Image pull complete")
-	return nil
+
+	if drift == "" {
+		log.Printf("Container %s already exists and matches the configuration, reusing it\n", c.ID)
+		return c.ID, nil
+	}
+
+	log.Printf("Container %s already exists but has drifted (%s), recreating it\n", c.ID, drift)
+	err = cli.ContainerRemove(ctx, c.ID, types.ContainerRemoveOptions{RemoveVolumes: true, Force: true})
+	if err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+// getImageRef returns the image reference (repo:tag) configured for the instance
+func getImageRef(i *t.Instance) (string, error) {
+	if isDockerfileDeploy(i) {
+		return getBuildImageRef(i), nil
+	}
+	repo := i.GetPropString(t.PropDockerImageName)
+	if repo == "" {
+		return "", errors.New("ABORT: DockerImageName Custom Property for the component must be populated with a valid Registry name")
+	}
+	tag := i.GetPropString(t.PropDockerImageTag)
+	return repo + ":" + tag, nil
 }
 
 func getScopedNetworkName(i *t.Instance) (networkName string) {
-	networkNameProp := strings.ToLower(i.GetPropFirstValue(propDockerNetwork))
+	networkNameProp := strings.ToLower(i.GetPropString(t.PropDockerNetwork))
 
 	var nameParts []string
-	networkScope := strings.ToLower(i.GetPropFirstValue(propDockerNetworkScope))
+	networkScope := strings.ToLower(i.GetPropEnum(t.PropDockerNetworkScope))
 	switch networkScope {
 	case "app":
 		nameParts = []string{"app", i.TenantAlias(), i.Workload.ApplicationAlias, i.Workload.VersionAlias}
@@ -389,12 +414,11 @@ func parseInstancePorts(i *t.Instance) (map[nat.Port]struct{}, map[nat.Port][]na
 	return nat.ParsePortSpecs(portSpecs)
 }
 
-func processBinds(i *t.Instance) ([]string, error) {
-	var archiveSrcDir string
-
+// getArchiveSrcDir returns the directory holding the component's archive content
+func getArchiveSrcDir(i *t.Instance) string {
 	// Locate archive source in pre-zipped-repo (introduced in ACP 6.6.0) deploy structure
 	if strings.HasPrefix(i.Platform.PlatformVersion, "6.5") {
-		archiveSrcDir = filepath.Join(
+		return filepath.Join(
 			i.Host.RepositoryDir,
 			i.TenantAlias(),
 			i.Workload.ApplicationAlias,
@@ -402,91 +426,133 @@ func processBinds(i *t.Instance) ([]string, error) {
 			"base/linuxServices",
 			i.Workload.BundleName,
 		)
-	} else {
-		tempDir := strings.Join([]string{i.Workload.InstanceID, "temp"}, "_")
-		archiveSrcDir = filepath.Join(
-			i.Host.Root,
-			i.Workload.InstanceID,
-			tempDir,
-			"workload",
-		)
 	}
+	tempDir := strings.Join([]string{i.Workload.InstanceID, "temp"}, "_")
+	return filepath.Join(
+		i.Host.Root,
+		i.Workload.InstanceID,
+		tempDir,
+		"workload",
+	)
+}
 
-	dirPermInt, err := strconv.Atoi(i.GetPropFirstValue(propDockerBindDirPermissions))
-	if err != nil {
-		dirPermInt = defaultBindsDirPermissions
+// getLocalBindRoot returns the host directory under which local (instance) binds are created
+func getLocalBindRoot(i *t.Instance) string {
+	return filepath.Join(i.Host.Root, i.Workload.InstanceID, "docker-binds")
+}
+
+// getSharedBindRoot returns the host directory under which shared binds are created
+func getSharedBindRoot(i *t.Instance) string {
+	return filepath.Join(
+		i.GetPropValid(t.PropDockerBindSharedRootDir),
+		i.TenantAlias(),
+		i.Workload.ApplicationAlias,
+		i.Workload.VersionAlias,
+	)
+}
+
+// getBindRoot returns the host directory under which a local or shared bind is created
+func getBindRoot(i *t.Instance, bind bindMount) string {
+	if bind.Type == "shared" {
+		return getSharedBindRoot(i)
 	}
-	dirPerm := os.FileMode(dirPermInt)
+	return getLocalBindRoot(i)
+}
+
+// bindMount describes a host directory bound into the container
+type bindMount struct {
+	Type          string `json:"type"`
+	HostPath      string `json:"hostPath"`
+	ContainerPath string `json:"containerPath"`
+	Options       string `json:"options,omitempty"`
+	Spec          string `json:"spec"`
+	SourceDir     string `json:"sourceDir,omitempty"`
+}
+
+// getBindMounts resolves the local, shared and host binds for the instance without touching the filesystem
+func getBindMounts(i *t.Instance) ([]bindMount, error) {
+	archiveSrcDir := getArchiveSrcDir(i)
 
 	// Process local (instance) binds, if any
-	lBinds := []string{}
-	lPaths := i.GetProp(propDockerBindLocal)
-	if len(lPaths) > 0 {
-		lBindRoot := filepath.Join(i.Host.Root, i.Workload.InstanceID, "docker-binds")
-		// Pre-create local bind directories with specified permissions
-		err = preCreatePathDirs(lPaths, lBindRoot, dirPerm)
-		if err != nil {
-			return []string{}, err
-		}
-		// Process local binds, copying dirs from src archive if available
-		lBinds, err = getBindsForPaths(lPaths, lBindRoot, archiveSrcDir)
-		if err != nil {
-			return []string{}, err
-		}
+	lBinds, err := getBindsForPaths("local", i.GetPropValues(t.PropDockerBindLocal), getLocalBindRoot(i), archiveSrcDir)
+	if err != nil {
+		return nil, err
 	}
 
 	// Process shared binds, if any
-	sBinds := []string{}
-	sPaths := i.GetProp(propDockerBindShared)
-	if len(sPaths) > 0 {
-		sBindRoot := i.GetPropFirstValue(propDockerBindSharedRootDir)
-		if sBindRoot == "" {
-			sBindRoot = defaultBindsDirShared
-		}
-		sBindRoot = filepath.Join(
-			sBindRoot,
-			i.TenantAlias(),
-			i.Workload.ApplicationAlias,
-			i.Workload.VersionAlias,
-		)
-		// Pre-create shared bind directories with specified permissions
-		err = preCreatePathDirs(sPaths, sBindRoot, dirPerm)
-		if err != nil {
-			return []string{}, err
-		}
-		// Process shared binds, copying dirs from src archive if available
-		sBinds, err = getBindsForPaths(sPaths, sBindRoot, archiveSrcDir)
-		if err != nil {
-			return []string{}, err
+	sBinds, err := getBindsForPaths("shared", i.GetPropValues(t.PropDockerBindShared), getSharedBindRoot(i), archiveSrcDir)
+	if err != nil {
+		return nil, err
+	}
+	if i.GetPropBool(t.PropDockerBindSharedNoRelabel) {
+		for _, bind := range sBinds {
+			if hasMountOption(bind.Options, "z") || hasMountOption(bind.Options, "Z") {
+				return nil, fmt.Errorf("ABORT: Shared bind %s cannot be relabeled, %s is set", bind.ContainerPath, t.PropDockerBindSharedNoRelabel)
+			}
 		}
 	}
 
 	// Process host binds, if any, validating against approved host dirs
-	hPaths := i.GetProp(propDockerBindHost)
-	hBinds := []string{}
+	hPaths := i.GetPropValues(t.PropDockerBindHost)
+	hBinds := []bindMount{}
 	if len(hPaths) > 0 {
-		hApprovedDirs := i.GetPropFirstValue(propDockerBindHostApprovedDirs)
-		if hApprovedDirs != "" {
-			hBinds, err = getBindsForHostPaths(hPaths, strings.Split(hApprovedDirs, ":"))
+		hApprovedDirs := i.GetPropPathList(t.PropDockerBindHostApprovedDirs)
+		hReadOnlyDirs := i.GetPropPathList(t.PropDockerBindHostReadOnlyDirs)
+		if len(hApprovedDirs) > 0 || len(hReadOnlyDirs) > 0 {
+			hBinds, err = getBindsForHostPaths(hPaths, hApprovedDirs, hReadOnlyDirs, i.GetPropBool(t.PropDockerBindHostForceReadOnly))
 			if err != nil {
-				return []string{}, err
+				return nil, err
 			}
 		} else {
-			return []string{}, errors.New("ABORT: Host binding is not currently allowed")
+			return nil, errors.New("ABORT: Host binding is not currently allowed")
 		}
 	}
 
 	return append(append(lBinds, sBinds...), hBinds...), nil
 }
 
-func preCreatePathDirs(paths []string, rootPath string, perm os.FileMode) error {
+// prepareBinds creates the local and shared bind directories and initializes them with archive content
+func prepareBinds(i *t.Instance, binds []bindMount) error {
+	dirPerm := i.GetPropFileMode(t.PropDockerBindDirPermissions)
+
+	// Pre-create bind directories with specified permissions
 	syscall.Umask(0)
-	for _, path := range paths {
-		localPath, _, err := getPaths(path, rootPath)
+	owner, err := getBindOwner(i)
+	if err != nil {
+		return err
+	}
+	for _, bind := range binds {
+		if bind.Type == "host" {
+			continue
+		}
+		err := checkBindPath(i, bind)
+		if err != nil {
+			return err
+		}
+		err = os.MkdirAll(bind.HostPath, dirPerm)
+		if err != nil {
+			return err
+		}
+		err = checkBindPath(i, bind)
 		if err != nil {
 			return err
 		}
-		err = os.MkdirAll(localPath, perm)
+		err = owner.chown(bind.HostPath)
+		if err != nil {
+			return err
+		}
+	}
+	// Copy dirs from src archive if available, replacing platform tokens in them
+	err = checkBindSpace(i, binds)
+	if err != nil {
+		return err
+	}
+	tokens := newTokenReplacer(i)
+	for _, bind := range binds {
+		if bind.SourceDir == "" {
+			continue
+		}
+		err := syncBind(i, bind, tokens)
 		if err != nil {
 			return err
 		}
@@ -494,21 +560,29 @@ func preCreatePathDirs(paths []string, rootPath string, perm os.FileMode) error
 	return nil
 }
 
-func getBindsForPaths(paths []string, rootPath, archiveSrcDir string) ([]string, error) {
-	binds := []string{}
+func getBindsForPaths(bindType string, paths []string, rootPath, archiveSrcDir string) ([]bindMount, error) {
+	binds := []bindMount{}
 	for _, path := range paths {
-		localPath, relPath, err := getPaths(path, rootPath)
+		options := ""
+		if n := strings.Index(path, ":"); n != -1 {
+			options = path[n+1:]
+		}
+		opts, err := parseBindOptions(options)
 		if err != nil {
-			return []string{}, err
+			return []bindMount{}, fmt.Errorf("ABORT: Bind mount %s: %s", path, err)
 		}
-		newBind := strings.Join([]string{localPath, path}, ":")
-		binds = append(binds, newBind)
-
-		srcDir := filepath.Join(archiveSrcDir, relPath)
-		err = copyDirIfExists(srcDir, localPath)
+		localPath, relPath, err := getPaths(path, rootPath)
 		if err != nil {
-			return []string{}, err
+			return []bindMount{}, err
 		}
+		binds = append(binds, bindMount{
+			Type:          bindType,
+			HostPath:      localPath,
+			ContainerPath: "/" + relPath,
+			Options:       opts.String(),
+			Spec:          bindSpec(localPath, "/"+relPath, opts),
+			SourceDir:     filepath.Join(archiveSrcDir, relPath),
+		})
 	}
 	return binds, nil
 }
@@ -522,123 +596,124 @@ func getPaths(path, rootPath string) (localPath, relativePath string, err error)
 	if colIdx := strings.Index(relativePath, ":"); colIdx > -1 {
 		relativePath = relativePath[0:colIdx]
 	}
-	localPath = filepath.Join(rootPath, relativePath)
-	return
-}
-
-func copyDirIfExists(source, dest string) error {
-	// check if the source exists and is a directory
-	src, err := os.Stat(source)
-	if err != nil {
-		return nil
+	if hasDotDot(relativePath) {
+		err = fmt.Errorf("ABORT: Bind mount %s must not contain ..", path)
+		return
 	}
-	if !src.IsDir() {
-		return errors.New("ABORT: Source is not a directory")
+	relativePath = strings.TrimPrefix(filepath.Clean("/"+relativePath), "/")
+	if relativePath == "" {
+		err = fmt.Errorf("ABORT: Bind mount %s must be below /", path)
+		return
 	}
-	return copyDir(source, dest)
-}
+	localPath = filepath.Join(rootPath, relativePath)
 
-func copyFile(source, dest string) (err error) {
-	sourcefile, err := os.Open(source)
+	// A container could have replaced part of the path with a link out of the bind root
+	_, ok, err := resolveWithin(localPath, rootPath)
 	if err != nil {
-		return err
+		return "", "", err
 	}
-	defer sourcefile.Close()
-
-	destfile, err := os.Create(dest)
-	if err != nil {
-		return err
-	}
-	defer destfile.Close()
-
-	_, err = io.Copy(destfile, sourcefile)
-	if err == nil {
-		sourceinfo, err2 := os.Stat(source)
-		if err2 != nil {
-			err = os.Chmod(dest, sourceinfo.Mode())
-		}
+	if !ok {
+		err = fmt.Errorf("ABORT: Bind mount %s resolves outside of %s", path, rootPath)
 	}
 	return
 }
 
-func copyDir(source, dest string) (err error) {
-
-	// get properties of source dir
-	sourceinfo, err := os.Stat(source)
-	if err != nil {
-		return err
-	}
-
-	// create dest dir
-	err = os.MkdirAll(dest, sourceinfo.Mode())
-	if err != nil {
-		return err
-	}
+// getBindsForHostPaths validates host binds against the approved directories, resolving links
+// first. Binds within a read-only directory, or all of them if forceReadOnly, are mounted read-only.
+func getBindsForHostPaths(paths, approvedDirs, readOnlyDirs []string, forceReadOnly bool) ([]bindMount, error) {
+	binds := []bindMount{}
+	rejected := []string{}
+	for _, path := range paths {
+		parts := strings.SplitN(path, ":", 3)
+		if len(parts) < 2 || !filepath.IsAbs(parts[0]) || hasDotDot(parts[0]) || !filepath.IsAbs(parts[1]) || hasDotDot(parts[1]) {
+			rejected = append(rejected, path)
+			continue
+		}
+		hostPath, err := resolvePath(filepath.Clean(parts[0]))
+		if err != nil {
+			return []bindMount{}, err
+		}
+		options := ""
+		if len(parts) == 3 {
+			options = parts[2]
+		}
+		opts, err := parseBindOptions(options)
+		if err != nil {
+			log.Printf("Host bind %s: %s\n", path, err)
+			rejected = append(rejected, path)
+			continue
+		}
 
-	directory, _ := os.Open(source)
-	objects, err := directory.Readdir(-1)
-	for _, obj := range objects {
-		sourcefilepointer := filepath.Join(source, obj.Name())
-		destinationfilepointer := filepath.Join(dest, obj.Name())
-		if obj.IsDir() {
-			// create sub-directories - recursively
-			err = copyDir(sourcefilepointer, destinationfilepointer)
+		readOnlyDir, err := findApprovedDir(hostPath, readOnlyDirs)
+		if err != nil {
+			return []bindMount{}, err
+		}
+		if readOnlyDir == "" {
+			approvedDir, err := findApprovedDir(hostPath, approvedDirs)
 			if err != nil {
-				fmt.Println(err)
+				return []bindMount{}, err
 			}
-		} else {
-			// perform copy
-			err = copyFile(sourcefilepointer, destinationfilepointer)
-			if err != nil {
-				fmt.Println(err)
+			if approvedDir == "" {
+				rejected = append(rejected, path)
+				continue
+			}
+		}
+		if readOnlyDir != "" || forceReadOnly {
+			if opts.Mode == "rw" {
+				log.Printf("Host bind %s must be read-only and cannot be mounted read-write\n", path)
+				rejected = append(rejected, path)
+				continue
 			}
+			opts.Mode = "ro"
 		}
+
+		containerPath := filepath.Clean(parts[1])
+		binds = append(binds, bindMount{
+			Type:          "host",
+			HostPath:      hostPath,
+			ContainerPath: containerPath,
+			Options:       opts.String(),
+			Spec:          bindSpec(hostPath, containerPath, opts),
+		})
 	}
-	return
+	if len(rejected) > 0 {
+		return []bindMount{}, fmt.Errorf("ABORT: The following host binds are not allowed: %s", strings.Join(rejected, ", "))
+	}
+	return binds, nil
 }
 
-func pathIsApproved(path string, approvedDirs []string) bool {
-	for _, approved := range approvedDirs {
-		if strings.HasPrefix(path, approved) {
+// hasMountOption tells whether a comma-separated list of mount options includes option
+func hasMountOption(options, option string) bool {
+	for _, o := range strings.Split(options, ",") {
+		if o == option {
 			return true
 		}
 	}
 	return false
 }
 
-func getBindsForHostPaths(paths, approvedDirs []string) ([]string, error) {
-	binds := []string{}
-	rejected := []string{}
-	for _, path := range paths {
-		if pathIsApproved(path, approvedDirs) {
-			binds = append(binds, path)
-		} else {
-			rejected = append(rejected, path)
-		}
-	}
-	if len(rejected) > 0 {
-		return []string{}, fmt.Errorf("ABORT: The following host binds are not allowed: %s", strings.Join(rejected, ", "))
-	}
-	return binds, nil
-}
-
 func containerStart(i *t.Instance) error {
 	err := os.Chdir(i.Token.Tokens["BASEPATH"])
 	if err != nil {
 		return err
 	}
 
+	st, err := getStateOrDerived(i)
+	if err != nil {
+		return err
+	}
+
 	cli, err := client.NewEnvClient()
 	if err != nil {
 		return err
 	}
-	err = cli.ContainerStart(ctx, i.ContainerName(), types.ContainerStartOptions{})
+	err = cli.ContainerStart(ctx, containerRef(st), types.ContainerStartOptions{})
 	if err != nil {
 		return err
 	}
 	log.Println("Container started")
 
-	c, err := cli.ContainerInspect(ctx, i.ContainerName())
+	c, err := cli.ContainerInspect(ctx, containerRef(st))
 	if err != nil {
 		return err
 	}
@@ -663,16 +738,15 @@ func containerStart(i *t.Instance) error {
 		return err
 	}
 
-	return nil
+	st.ForwarderPid, err = readLogForwarderPid(i)
+	if err != nil {
+		log.Println("Unable to read logstash-forwarder PID:", err)
+	}
+	return saveState(i, st, "start", fmt.Sprintf("Started container with PID %d", c.State.Pid))
 }
 
 func checkWorkloadReadiness(i *t.Instance) error {
-	checkReadiness := i.GetPropFirstValue(propDockerReadinessCheck)
-	// Check for deprecated property name. To be removed in a future version.
-	if checkReadiness == "" {
-		checkReadiness = i.GetPropFirstValue(propDockerHealthCheck)
-	}
-	if checkReadiness == "Yes" {
+	if i.GetPropBool(t.PropDockerReadinessCheck) {
 		log.Println("Starting readiness checks")
 		for _, portDef := range i.Process.Ports.Allocated {
 			if portDef.PortType.Value == "Http" {
@@ -680,54 +754,42 @@ func checkWorkloadReadiness(i *t.Instance) error {
 				transport := &http.Transport{
 					TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
 				httpClient := &http.Client{Transport: transport}
-				scheme := i.GetPropFirstValue(propDockerReadinessCheckScheme)
-				// Check for deprecated property name. To be removed in a future version.
-				if scheme == "" {
-					scheme = i.GetPropFirstValue(propDockerHealthCheckScheme)
-				}
-				if scheme != "http" && scheme != "https" {
-					scheme = "http"
-				}
-				path := i.GetPropFirstValue(propDockerReadinessCheckPath)
-				// Check for deprecated property name. To be removed in a future version.
-				if path == "" {
-					path = i.GetPropFirstValue(propDockerHealthCheckPath)
-				}
-				if !strings.HasPrefix(path, "/") {
-					path = "/"
-				}
 				checkURL := url.URL{
-					Scheme: scheme,
+					Scheme: i.GetPropEnum(t.PropDockerReadinessCheckScheme),
 					Host:   "localhost:" + outPort,
-					Path:   path,
-				}
-				timeout, err := strconv.Atoi(i.GetPropFirstValue(propDockerReadinessCheckTimeoutSecs))
-				if err != nil {
-					// Check for deprecated property name. To be removed in a future version.
-					timeout, err = strconv.Atoi(i.GetPropFirstValue(propDockerHealthCheckTimeoutSecs))
-					if err != nil {
-						timeout = 300
-					}
+					Path:   i.GetPropValid(t.PropDockerReadinessCheckPath),
 				}
-				maxTime := time.Duration(timeout) * time.Second
+				maxTime := i.GetPropDuration(t.PropDockerReadinessCheckTimeoutSecs)
 				elapsed := time.Duration(0)
 				t0 := time.Now()
-				for i := 1; elapsed < maxTime; elapsed = time.Now().Sub(t0) {
-					log.Println("Health check try #", i)
+				result := &t.ReadinessResult{URL: checkURL.String()}
+				for ; elapsed < maxTime; elapsed = time.Now().Sub(t0) {
+					result.Attempts++
+					log.Println("Health check try #", result.Attempts)
 					resp, err := httpClient.Get(checkURL.String())
 					if err != nil || resp.StatusCode >= 300 {
 						if err != nil {
 							log.Println(err)
+							result.Error = err.Error()
 						} else {
 							log.Println("HTTP Rsponse Status Code: ", resp.StatusCode)
+							result.StatusCode = resp.StatusCode
+							result.Error = ""
+							resp.Body.Close()
 						}
 						log.Println("Sleeping for 500 milliseconds...")
 						time.Sleep(500 * time.Millisecond)
 					} else {
 						log.Println("Health check PASSED. HTTP Rsponse Status Code: ", resp.StatusCode)
+						resp.Body.Close()
+						result.StatusCode = resp.StatusCode
+						result.Error = ""
+						result.Passed = true
+						saveReadinessResult(i, result, time.Now().Sub(t0))
 						return nil
 					}
 				}
+				saveReadinessResult(i, result, elapsed)
 				return errors.New("ABORT: Health check timout reached")
 			}
 		}
@@ -735,6 +797,25 @@ func checkWorkloadReadiness(i *t.Instance) error {
 	return nil
 }
 
+// saveReadinessResult records the outcome of the last readiness check so it can be reported by status
+func saveReadinessResult(i *t.Instance, result *t.ReadinessResult, elapsed time.Duration) {
+	result.CheckedAt = time.Now().UTC()
+	result.ElapsedSecs = elapsed.Seconds()
+	b, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	err = ioutil.WriteFile(getReadinessResultPath(i), b, 0644)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func getReadinessResultPath(i *t.Instance) string {
+	return filepath.Join(i.Token.Tokens["BASEPATH"], readinessResultFileName)
+}
+
 func createMonitorFiles(i *t.Instance, c *types.ContainerJSON, dockerVersion string) error {
 	pidFile := os.Getenv("APPRENDA_WORKLOAD_PIDFILE")
 	if pidFile == "" {
@@ -861,36 +942,40 @@ func getLogConfigPath(i *t.Instance) string {
 }
 
 func containerStop(i *t.Instance) error {
+	st, err := getStateOrDerived(i)
+	if err != nil {
+		return err
+	}
+
 	cli, err := client.NewEnvClient()
 	if err != nil {
 		return err
 	}
 	timeout := 30 * time.Second
-	err = cli.ContainerStop(ctx, i.ContainerName(), &timeout)
+	err = cli.ContainerStop(ctx, containerRef(st), &timeout)
 	if err != nil {
 		return err
 	}
 	log.Println("Container stopped")
 
-	err = stopLogForwarder(i)
+	err = stopLogForwarder(i, st.ForwarderPid)
 	if err != nil {
 		return err
 	}
 	log.Println("Stopped logstash-forwarder")
 
-	return nil
+	st.ForwarderPid = 0
+	return saveState(i, st, "stop", "")
 }
 
-func stopLogForwarder(i *t.Instance) error {
-	f, err := os.Open(filepath.Join(i.Token.Tokens["BASEPATH"], "logstash_forwarder.pid"))
-	defer f.Close()
-	if err != nil {
-		return err
-	}
-	var pid int
-	_, err = fmt.Fscan(f, &pid)
-	if err != nil {
-		return err
+// stopLogForwarder kills the logstash-forwarder process, using the PID file if pid is not known
+func stopLogForwarder(i *t.Instance, pid int) error {
+	if pid == 0 {
+		var err error
+		pid, err = readLogForwarderPid(i)
+		if err != nil {
+			return err
+		}
 	}
 	proc, err := os.FindProcess(pid)
 	if err != nil {
@@ -899,7 +984,27 @@ func stopLogForwarder(i *t.Instance) error {
 	return proc.Kill()
 }
 
+func readLogForwarderPid(i *t.Instance) (int, error) {
+	f, err := os.Open(getLogForwarderPidPath(i))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var pid int
+	_, err = fmt.Fscan(f, &pid)
+	return pid, err
+}
+
+func getLogForwarderPidPath(i *t.Instance) string {
+	return filepath.Join(i.Token.Tokens["BASEPATH"], logForwarderPidFileName)
+}
+
 func containerRemove(i *t.Instance) error {
+	st, err := getStateOrDerived(i)
+	if err != nil {
+		return err
+	}
+
 	cli, err := client.NewEnvClient()
 	if err != nil {
 		return err
@@ -908,46 +1013,24 @@ func containerRemove(i *t.Instance) error {
 		RemoveVolumes: true,
 		Force:         true,
 	}
-	err = cli.ContainerRemove(ctx, i.ContainerName(), options)
+	err = cli.ContainerRemove(ctx, containerRef(st), options)
 	if err != nil {
 		return err
 	}
 	log.Println("Container removed")
-	networkScope := strings.ToLower(i.GetPropFirstValue(propDockerNetworkScope))
-	if networkScope != "" {
-		networkName := getScopedNetworkName(i)
-		err = cli.NetworkRemove(ctx, networkName)
-		if err != nil {
-			log.Println(err.Error())
+	for _, n := range st.Networks {
+		if !n.Scoped {
+			continue
 		}
-	}
-	removeImage := i.GetPropFirstValue(propDockerRemoveImage)
-	// Check for deprecated property name. To be removed in a future version.
-	if removeImage == "" {
-		removeImage = i.GetPropFirstValue(propDockerImageRemove)
-	}
-	if removeImage == "Yes" {
-		err = imageRemove(i)
+		err = cli.NetworkRemove(ctx, n.Name)
 		if err != nil {
-			return err
+			log.Println(err.Error())
 		}
 	}
-	return nil
-}
-
-func imageRemove(i *t.Instance) error {
-	cli, err := client.NewEnvClient()
-	if err != nil {
-		return err
-	}
-	options := types.ImageRemoveOptions{
-		PruneChildren: true,
-	}
-	_, err = cli.ImageRemove(ctx, i.GetPropFirstValue(propDockerImageName), options)
+	// Collecting images is housekeeping, it must not fail the undeploy
+	err = imageGC(cli, i, gcOptions{Released: []string{st.ImageID}, RemoveReleased: i.GetPropBool(t.PropDockerRemoveImage)})
 	if err != nil {
-		log.Println("Image not removed because other containers are still running")
-	} else {
-		log.Println("Image removed")
+		log.Println("Unable to collect unused images:", err)
 	}
-	return nil
+	return saveState(i, st, "undeploy", "Removed container "+containerRef(st))
 }
@@ -0,0 +1,237 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+	"time"
+
+	t "github.com/claudiobernardoromao/docker-img-deployer/types"
+)
+
+const (
+	mediaTypeManifestV2   = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+// Registry API calls are only used for estimates, so they must not hold up a deployment for long
+const registryRequestTimeout = 30 * time.Second
+
+// Docker Hub serves its API from a different host than the registry name used in image references
+const dockerHubAPIHost = "registry-1.docker.io"
+
+type registryManifest struct {
+	MediaType string `json:"mediaType"`
+	Config    struct {
+		Size int64 `json:"size"`
+	} `json:"config"`
+	Layers []struct {
+		Size int64 `json:"size"`
+	} `json:"layers"`
+	Manifests []struct {
+		Digest   string `json:"digest"`
+		Platform struct {
+			Architecture string `json:"architecture"`
+			OS           string `json:"os"`
+		} `json:"platform"`
+	} `json:"manifests"`
+}
+
+// getImageDownloadSize asks the registry for the manifest of ref and returns the compressed size
+// of its config and layers
+func getImageDownloadSize(i *t.Instance, ref string) (int64, error) {
+	registry, repo, tag, digest := parseImageRef(ref)
+	reference := digest
+	if reference == "" {
+		reference = tag
+	}
+	if reference == "" {
+		reference = "latest"
+	}
+
+	m, err := fetchManifest(i, registry, repo, reference)
+	if err != nil {
+		return 0, err
+	}
+	if m.MediaType == mediaTypeManifestList {
+		platformDigest := ""
+		for _, pm := range m.Manifests {
+			if pm.Platform.OS == runtime.GOOS && pm.Platform.Architecture == runtime.GOARCH {
+				platformDigest = pm.Digest
+				break
+			}
+		}
+		if platformDigest == "" {
+			return 0, fmt.Errorf("no %s/%s manifest for %s", runtime.GOOS, runtime.GOARCH, ref)
+		}
+		m, err = fetchManifest(i, registry, repo, platformDigest)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	size := m.Config.Size
+	for _, layer := range m.Layers {
+		size += layer.Size
+	}
+	return size, nil
+}
+
+func fetchManifest(i *t.Instance, registry, repo, reference string) (*registryManifest, error) {
+	host := registry
+	if host == dockerHubRegistry {
+		host = dockerHubAPIHost
+	}
+	scheme := "https"
+	if isInsecureRegistryAllowed(i, registry) {
+		scheme = "http"
+	}
+	manifestURL := fmt.Sprintf("%s://%s/v2/%s/manifests/%s", scheme, host, repo, reference)
+
+	httpClient := &http.Client{Timeout: registryRequestTimeout}
+	newRequest := func() (*http.Request, error) {
+		req, err := http.NewRequest("GET", manifestURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", mediaTypeManifestV2+", "+mediaTypeManifestList)
+		return req, nil
+	}
+
+	req, err := newRequest()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Registries requiring a token tell where to get it in the challenge
+	if resp.StatusCode == http.StatusUnauthorized {
+		token, err := getRegistryToken(i, httpClient, registry, resp.Header.Get("Www-Authenticate"))
+		if err != nil {
+			return nil, err
+		}
+		req, err = newRequest()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", token)
+		resp, err = httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s returned %s", manifestURL, resp.Status)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	m := &registryManifest{}
+	err = json.Unmarshal(b, m)
+	if err != nil {
+		return nil, err
+	}
+	if m.MediaType == "" {
+		m.MediaType = resp.Header.Get("Content-Type")
+	}
+	return m, nil
+}
+
+// getRegistryToken answers a registry authentication challenge, returning the Authorization header to use
+func getRegistryToken(i *t.Instance, httpClient *http.Client, registry, challenge string) (string, error) {
+	auth, _, err := findRegistryCredentials(i, registry)
+	if err != nil {
+		return "", err
+	}
+
+	scheme := strings.SplitN(challenge, " ", 2)
+	if strings.EqualFold(scheme[0], "Basic") {
+		if auth == nil {
+			return "", fmt.Errorf("registry %s requires credentials", registry)
+		}
+		req, _ := http.NewRequest("GET", "", nil)
+		req.SetBasicAuth(auth.Username, auth.Password)
+		return req.Header.Get("Authorization"), nil
+	}
+	if !strings.EqualFold(scheme[0], "Bearer") || len(scheme) < 2 {
+		return "", fmt.Errorf("unsupported authentication challenge from %s: %q", registry, challenge)
+	}
+
+	params := map[string]string{}
+	for _, param := range strings.Split(scheme[1], ",") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) == 2 {
+			params[strings.ToLower(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+	query := url.Values{}
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	req, err := http.NewRequest("GET", params["realm"]+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	// The realm is named by the registry, only send it credentials over a secure connection
+	if auth != nil && req.URL.Scheme == "https" {
+		req.SetBasicAuth(auth.Username, auth.Password)
+	} else if auth != nil {
+		log.Printf("Not sending the credentials for %s to token realm %s, it does not use https\n", registry, params["realm"])
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request to %s returned %s", params["realm"], resp.Status)
+	}
+	var tokenResp struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&tokenResp)
+	if err != nil {
+		return "", err
+	}
+	if tokenResp.Token == "" {
+		tokenResp.Token = tokenResp.AccessToken
+	}
+	return "Bearer " + tokenResp.Token, nil
+}
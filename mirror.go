@@ -0,0 +1,141 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	t "github.com/claudiobernardoromao/docker-img-deployer/types"
+	"github.com/docker/docker/api/types"
+	registrytypes "github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/client"
+)
+
+// getMirrorRef returns ref rewritten to the mirror configured for its registry, or an empty
+// string if images from that registry are not mirrored
+func getMirrorRef(i *t.Instance, ref string) string {
+	registry, repo, tag, digest := parseImageRef(ref)
+	for _, value := range i.GetPropValues(t.PropDockerRegistryMirrors) {
+		kv := strings.SplitN(value, "=", 2)
+		if len(kv) != 2 || normalizeRegistryHost(strings.TrimSpace(kv[0])) != registry {
+			continue
+		}
+		mirrorRef := strings.TrimSuffix(strings.TrimSpace(kv[1]), "/") + "/" + repo
+		if digest != "" {
+			return mirrorRef + "@" + digest
+		}
+		if tag == "" {
+			tag = "latest"
+		}
+		return mirrorRef + ":" + tag
+	}
+	return ""
+}
+
+// getLocalImage inspects the local image for ref, which may only be known under its mirror
+// reference when it was pulled by digest from a mirror. It returns the reference found.
+func getLocalImage(cli *client.Client, i *t.Instance, ref string) (string, types.ImageInspect, error) {
+	img, _, err := cli.ImageInspectWithRaw(ctx, ref)
+	if client.IsErrImageNotFound(err) {
+		if mirrorRef := getMirrorRef(i, ref); mirrorRef != "" {
+			if mirrorImg, _, mirrorErr := cli.ImageInspectWithRaw(ctx, mirrorRef); mirrorErr == nil {
+				return mirrorRef, mirrorImg, nil
+			}
+		}
+	}
+	return ref, img, err
+}
+
+// adoptMirrorImage makes an image pulled from a mirror available under its original reference.
+// References by digest cannot be tagged, so those images keep their mirror reference only.
+func adoptMirrorImage(cli *client.Client, mirrorRef, ref string, existing map[string]bool) error {
+	trackImage(cli, mirrorRef, existing)
+	if isDigestRef(ref) {
+		return nil
+	}
+	err := cli.ImageTag(ctx, mirrorRef, ref)
+	if err != nil {
+		return err
+	}
+	trackImage(cli, ref, existing)
+	return nil
+}
+
+// isInsecureRegistryAllowed tells whether the deployment may reach registry over plain HTTP
+func isInsecureRegistryAllowed(i *t.Instance, registry string) bool {
+	if strings.EqualFold(i.Workload.Stage.Value, "Published") {
+		return false
+	}
+	for _, value := range i.GetPropValues(t.PropDockerInsecureRegistries) {
+		if normalizeRegistryHost(strings.TrimSpace(value)) == registry {
+			return true
+		}
+	}
+	return false
+}
+
+// checkInsecureRegistry aborts if the Docker daemon would reach the registry of ref over plain
+// HTTP but the deployment is not allowed to
+func checkInsecureRegistry(cli *client.Client, i *t.Instance, ref string) error {
+	registry, _ := splitImageRef(ref)
+	info, err := cli.Info(ctx)
+	if err != nil {
+		return err
+	}
+	if info.RegistryConfig == nil || isSecureRegistry(info.RegistryConfig, registry) || isInsecureRegistryAllowed(i, registry) {
+		return nil
+	}
+	if strings.EqualFold(i.Workload.Stage.Value, "Published") {
+		return fmt.Errorf("ABORT: Registry %s is insecure (plain HTTP) and cannot be used in the Published stage", registry)
+	}
+	return fmt.Errorf("ABORT: Registry %s is insecure (plain HTTP) and is not listed in %s", registry, t.PropDockerInsecureRegistries)
+}
+
+// isSecureRegistry tells whether the Docker daemon reaches registry over TLS, following the
+// daemon's rules: a registry configured by name wins, otherwise the registry is insecure if
+// its host resolves to an address in one of the insecure CIDRs (127.0.0.0/8 by default)
+func isSecureRegistry(config *registrytypes.ServiceConfig, registry string) bool {
+	if index, ok := config.IndexConfigs[registry]; ok {
+		return index.Secure
+	}
+	host, _, err := net.SplitHostPort(registry)
+	if err != nil {
+		host = registry
+	}
+	addrs, err := net.LookupIP(host)
+	if err != nil {
+		if ip := net.ParseIP(host); ip != nil {
+			addrs = []net.IP{ip}
+		}
+	}
+	for _, addr := range addrs {
+		for _, cidr := range config.InsecureRegistryCIDRs {
+			if (*net.IPNet)(cidr).Contains(addr) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,67 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"net"
+	"testing"
+
+	registrytypes "github.com/docker/docker/api/types/registry"
+)
+
+func TestIsSecureRegistry(t *testing.T) {
+	config := &registrytypes.ServiceConfig{
+		IndexConfigs: map[string]*registrytypes.IndexInfo{
+			"docker.io":          {Name: "docker.io", Secure: true},
+			"insecure.test:5000": {Name: "insecure.test:5000", Secure: false},
+			"127.0.0.1:5000":     {Name: "127.0.0.1:5000", Secure: true},
+		},
+	}
+	for _, cidr := range []string{"127.0.0.0/8", "10.20.0.0/16", "fd00::/8"} {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.InsecureRegistryCIDRs = append(config.InsecureRegistryCIDRs, (*registrytypes.NetIPNet)(ipnet))
+	}
+
+	tests := []struct {
+		registry string
+		secure   bool
+	}{
+		{"docker.io", true},
+		{"insecure.test:5000", false},
+		{"127.0.0.1:5000", true},
+		{"127.0.0.2:5000", false},
+		{"localhost:5000", false},
+		{"10.20.30.40", false},
+		{"10.21.0.1:443", true},
+		{"[fd00::1]:5000", false},
+		{"192.0.2.1:5000", true},
+	}
+	for _, test := range tests {
+		if got := isSecureRegistry(config, test.registry); got != test.secure {
+			t.Errorf("isSecureRegistry(%s) = %v, want %v", test.registry, got, test.secure)
+		}
+	}
+}
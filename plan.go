@@ -0,0 +1,67 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+
+	t "github.com/claudiobernardoromao/docker-img-deployer/types"
+)
+
+// containerPlan prints the container spec that deploy would create, without deploying it
+func containerPlan(i *t.Instance, args []string) error {
+	flags := flag.NewFlagSet("plan", flag.ContinueOnError)
+	format := flags.String("format", "all", "Output format: json, docker or all")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	spec, err := buildContainerSpec(i)
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "json", "docker", "all":
+	default:
+		return fmt.Errorf("Unknown plan format %q, expected json, docker or all", *format)
+	}
+
+	if *format != "docker" {
+		b, err := json.MarshalIndent(spec, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+	}
+	if *format == "all" {
+		fmt.Println()
+	}
+	if *format != "json" {
+		fmt.Println(spec.dockerRunCommand())
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	t "github.com/claudiobernardoromao/docker-img-deployer/types"
+)
+
+// Host file holding the image policy. It is not configurable from Custom Properties, which
+// would let a component point it elsewhere and deploy outside of the policy.
+const imagePolicyFile = "/etc/apprenda/docker-image-policy.json"
+
+// loadImagePolicy reads the host image policy. It returns nil if there is no policy file, and
+// aborts the deployment if the file exists but cannot be read.
+func loadImagePolicy() (*t.ImagePolicy, error) {
+	policy := &t.ImagePolicy{}
+	found, err := readJSONFileIfExists(imagePolicyFile, policy)
+	if err != nil {
+		return nil, fmt.Errorf("ABORT: Unable to read the image policy: %s", err)
+	}
+	if !found {
+		return nil, nil
+	}
+	return policy, nil
+}
+
+// parseImageRef splits ref into its registry host, repository path, tag and digest
+func parseImageRef(ref string) (registry, repo, tag, digest string) {
+	registry, remainder := splitImageRef(ref)
+	if n := strings.Index(remainder, "@"); n != -1 {
+		digest = remainder[n+1:]
+		remainder = remainder[:n]
+	}
+	if n := strings.LastIndex(remainder, ":"); n != -1 {
+		tag = remainder[n+1:]
+		remainder = remainder[:n]
+	}
+	repo = remainder
+	if registry == dockerHubRegistry && !strings.Contains(repo, "/") {
+		repo = "library/" + repo
+	}
+	return registry, repo, tag, digest
+}
+
+// checkImagePolicy aborts the deployment if ref is not allowed by the host image policy
+func checkImagePolicy(i *t.Instance, ref string) error {
+	policy, err := loadImagePolicy()
+	if err != nil || policy == nil {
+		return err
+	}
+	registry, repo, tag, digest := parseImageRef(ref)
+
+	if len(policy.AllowedRegistries) > 0 && !containsFold(policy.AllowedRegistries, registry) {
+		return policyViolation(ref, "registry %s is not in allowedRegistries", registry)
+	}
+
+	if len(policy.AllowedRepositories) > 0 {
+		allowed := false
+		for _, pattern := range policy.AllowedRepositories {
+			if matched, _ := path.Match(pattern, registry+"/"+repo); matched {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return policyViolation(ref, "repository %s/%s does not match allowedRepositories", registry, repo)
+		}
+	}
+
+	if tag != "" {
+		for stage, tags := range policy.DeniedTags {
+			if (stage == "*" || strings.EqualFold(stage, i.Workload.Stage.Value)) && containsFold(tags, tag) {
+				return policyViolation(ref, "tag %s is in deniedTags for stage %s", tag, stage)
+			}
+		}
+	}
+
+	if digest != "" {
+		return checkImageDigestPolicy(i, ref, []string{digest})
+	}
+	return nil
+}
+
+// checkDeployImagePolicy checks the image reference configured for the instance and ref, the
+// reference deployed. Tag rules only apply to the former, since a ref pinned to a digest has no tag.
+func checkDeployImagePolicy(i *t.Instance, ref string) error {
+	configured, err := getImageRef(i)
+	if err != nil {
+		return err
+	}
+	err = checkImagePolicy(i, configured)
+	if err != nil || ref == configured {
+		return err
+	}
+	return checkImagePolicy(i, ref)
+}
+
+// checkImageDigestPolicy aborts the deployment if any digest of the image behind ref is denied
+func checkImageDigestPolicy(i *t.Instance, ref string, digests []string) error {
+	policy, err := loadImagePolicy()
+	if err != nil || policy == nil {
+		return err
+	}
+	for _, digest := range digests {
+		if n := strings.Index(digest, "@"); n != -1 {
+			digest = digest[n+1:]
+		}
+		if containsFold(policy.DeniedDigests, digest) {
+			return policyViolation(ref, "digest %s is in deniedDigests", digest)
+		}
+	}
+	return nil
+}
+
+func policyViolation(ref, format string, args ...interface{}) error {
+	return fmt.Errorf("ABORT: Image %s is not allowed by the image policy: "+format, append([]interface{}{ref}, args...)...)
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import "testing"
+
+func TestSplitImageRef(t *testing.T) {
+	tests := []struct {
+		ref, registry, remainder string
+	}{
+		{"nginx", "docker.io", "nginx"},
+		{"nginx:1.11", "docker.io", "nginx:1.11"},
+		{"team/app:2.0", "docker.io", "team/app:2.0"},
+		{"docker.io/library/nginx", "docker.io", "library/nginx"},
+		{"index.docker.io/team/app", "docker.io", "team/app"},
+		{"registry-1.docker.io/team/app", "docker.io", "team/app"},
+		{"registry.example.com/team/app:2.0", "registry.example.com", "team/app:2.0"},
+		{"registry.example.com:5000/app", "registry.example.com:5000", "app"},
+		{"localhost/app", "localhost", "app"},
+		{"localhost:5000/app", "localhost:5000", "app"},
+		{"registry:5000/app", "registry:5000", "app"},
+		{"team.name/app", "team.name", "app"},
+	}
+	for _, test := range tests {
+		registry, remainder := splitImageRef(test.ref)
+		if registry != test.registry || remainder != test.remainder {
+			t.Errorf("splitImageRef(%q) = %q, %q, want %q, %q", test.ref, registry, remainder, test.registry, test.remainder)
+		}
+	}
+}
+
+func TestParseImageRef(t *testing.T) {
+	tests := []struct {
+		ref, registry, repo, tag, digest string
+	}{
+		{"nginx", "docker.io", "library/nginx", "", ""},
+		{"nginx:latest", "docker.io", "library/nginx", "latest", ""},
+		{"library/nginx:1.11", "docker.io", "library/nginx", "1.11", ""},
+		{"team/app:2.0", "docker.io", "team/app", "2.0", ""},
+		{"nginx@sha256:0123", "docker.io", "library/nginx", "", "sha256:0123"},
+		{"nginx:1.11@sha256:0123", "docker.io", "library/nginx", "1.11", "sha256:0123"},
+		{"registry.example.com:5000/team/app", "registry.example.com:5000", "team/app", "", ""},
+		{"registry.example.com:5000/team/app:2.0", "registry.example.com:5000", "team/app", "2.0", ""},
+		{"registry.example.com:5000/app@sha256:0123", "registry.example.com:5000", "app", "", "sha256:0123"},
+		{"localhost:5000/app", "localhost:5000", "app", "", ""},
+	}
+	for _, test := range tests {
+		registry, repo, tag, digest := parseImageRef(test.ref)
+		if registry != test.registry || repo != test.repo || tag != test.tag || digest != test.digest {
+			t.Errorf("parseImageRef(%q) = %q, %q, %q, %q, want %q, %q, %q, %q", test.ref, registry, repo, tag, digest, test.registry, test.repo, test.tag, test.digest)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"log"
+	"strings"
+
+	t "github.com/claudiobernardoromao/docker-img-deployer/types"
+	"github.com/docker/docker/client"
+)
+
+// runPrefetch implements the prefetch command, which pulls an image ahead of its deployment to
+// warm a node. It takes an image reference or the path of an instance.json, and defaults to the
+// instance.json of the current instance.
+func runPrefetch(args []string) error {
+	cli, err := client.NewEnvClient()
+	if err != nil {
+		return err
+	}
+
+	arg := ""
+	if len(args) > 0 {
+		arg = args[0]
+	}
+	if arg != "" && !strings.HasSuffix(arg, ".json") {
+		// A bare reference uses the current instance's properties for credentials and retries if there is one
+		i, err := getInstance()
+		if err != nil {
+			i = &t.Instance{}
+		}
+		return prefetchImage(cli, i, arg)
+	}
+
+	var i *t.Instance
+	if arg == "" {
+		i, err = getInstance()
+	} else {
+		i, err = loadInstance(arg)
+	}
+	if err != nil {
+		return err
+	}
+	return prefetchInstance(cli, i)
+}
+
+// prefetchInstance pulls the image an instance would deploy, then resolves and pins its digest the
+// same way deploy does. Pulling first means a stale local copy of a moved tag is never pinned.
+func prefetchInstance(cli *client.Client, i *t.Instance) error {
+	if isDockerfileDeploy(i) || i.GetPropString(t.PropDockerImageArchive) != "" {
+		log.Println("Nothing to prefetch, the image of this component comes from its archive")
+		return nil
+	}
+	spec, err := buildContainerSpec(i)
+	if err != nil {
+		return err
+	}
+	err = checkDeployImagePolicy(i, spec.Image)
+	if err != nil {
+		return err
+	}
+	ref := spec.Image
+	err = prefetchImage(cli, i, ref)
+	if err != nil {
+		return err
+	}
+	err = pinImageDigest(cli, i, spec)
+	if err != nil || spec.Image == ref {
+		return err
+	}
+	// Another instance may have pinned a different digest in the meantime
+	if _, _, err := getLocalImage(cli, i, spec.Image); err == nil {
+		return nil
+	}
+	return prefetchImage(cli, i, spec.Image)
+}
+
+// prefetchImage pulls ref even if it is present, so that a moved tag is refreshed
+func prefetchImage(cli *client.Client, i *t.Instance, ref string) error {
+	err := checkImagePolicy(i, ref)
+	if err != nil {
+		return err
+	}
+	err = imagePull(cli, i, ref)
+	if err != nil {
+		return err
+	}
+	ref, img, err := getLocalImage(cli, i, ref)
+	if err != nil {
+		return err
+	}
+	err = checkImageDigestPolicy(i, ref, img.RepoDigests)
+	if err != nil {
+		return err
+	}
+	log.Printf("Prefetched %s as %s\n", ref, img.ID)
+	return nil
+}
@@ -0,0 +1,161 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Interval between progress summaries written to the log
+const progressInterval = 10 * time.Second
+
+// progressMessage is a message of the JSON stream returned by the daemon when pulling, loading or building images
+type progressMessage struct {
+	Stream   string `json:"stream"`
+	Status   string `json:"status"`
+	ID       string `json:"id"`
+	Progress *struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"progressDetail"`
+	Error       string `json:"error"`
+	ErrorDetail *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+}
+
+// layerProgress is the last known state of a layer
+type layerProgress struct {
+	status  string
+	current int64
+	total   int64
+}
+
+// progressResult summarizes a completed progress stream
+type progressResult struct {
+	Digest string
+	Status string
+}
+
+// readProgress decodes a progress stream, logging a summary of per-layer progress every
+// progressInterval. Errors reported inside the stream are returned as errors.
+func readProgress(r io.Reader, action string) (*progressResult, error) {
+	result := &progressResult{}
+	layers := map[string]*layerProgress{}
+	lastSummary := time.Now()
+	dec := json.NewDecoder(r)
+	for {
+		var msg progressMessage
+		err := dec.Decode(&msg)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, fmt.Errorf("Unable to read %s progress: %s", action, err)
+		}
+
+		if msg.ErrorDetail != nil && msg.ErrorDetail.Message != "" {
+			return result, errors.New(msg.ErrorDetail.Message)
+		}
+		if msg.Error != "" {
+			return result, errors.New(msg.Error)
+		}
+
+		if msg.Stream != "" {
+			for _, line := range strings.Split(strings.TrimRight(msg.Stream, "\n"), "\n") {
+				log.Println(line)
+			}
+		}
+
+		if msg.ID == "" || strings.HasPrefix(msg.Status, "Pulling from") {
+			// Image-wide messages such as "Digest: sha256:..." or "Status: Downloaded newer image"
+			if msg.Status != "" {
+				log.Println(msg.Status)
+				result.Status = msg.Status
+				if strings.HasPrefix(msg.Status, "Digest: ") {
+					result.Digest = strings.TrimPrefix(msg.Status, "Digest: ")
+				}
+			}
+		} else {
+			layer, ok := layers[msg.ID]
+			if !ok {
+				layer = &layerProgress{}
+				layers[msg.ID] = layer
+			}
+			layer.status = msg.Status
+			if msg.Progress != nil && msg.Progress.Total > 0 {
+				layer.current, layer.total = msg.Progress.Current, msg.Progress.Total
+			}
+			if strings.HasSuffix(msg.Status, "complete") {
+				layer.current = layer.total
+			}
+		}
+
+		if time.Since(lastSummary) >= progressInterval {
+			logProgressSummary(action, layers)
+			lastSummary = time.Now()
+		}
+	}
+	if len(layers) > 0 {
+		logProgressSummary(action, layers)
+	}
+	return result, nil
+}
+
+func logProgressSummary(action string, layers map[string]*layerProgress) {
+	var current, total int64
+	statuses := map[string]int{}
+	for _, layer := range layers {
+		current += layer.current
+		total += layer.total
+		statuses[layer.status]++
+	}
+	counts := []string{}
+	for status, count := range statuses {
+		counts = append(counts, fmt.Sprintf("%d %s", count, status))
+	}
+	sort.Strings(counts)
+	log.Printf("%s progress: %d layers (%s), %s of %s transferred\n",
+		action, len(layers), strings.Join(counts, ", "), formatBytes(current), formatBytes(total))
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,193 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/context"
+
+	t "github.com/claudiobernardoromao/docker-img-deployer/types"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// Directory holding the lock files that serialize pulls of the same image on a host
+const pullLockDir = "/var/lock/apprenda-docker-deployer"
+
+// imagePull pulls ref, from its registry mirror first if there is one, retrying transient failures
+// with exponential backoff until the pull deadline. Concurrent deployer processes on the host
+// pulling the same ref wait for the first one instead of pulling in parallel.
+func imagePull(cli *client.Client, i *t.Instance, ref string) error {
+	timeout := i.GetPropDuration(t.PropDockerPullTimeoutSecs)
+	pullCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	unlock, waited, err := lockImageRef(pullCtx, ref)
+	if err != nil {
+		return fmt.Errorf("ABORT: Unable to pull %s: %s", ref, err)
+	}
+	defer unlock()
+	if waited {
+		if _, _, err := getLocalImage(cli, i, ref); err == nil {
+			log.Printf("Image %s was pulled by another deployer process in the meantime\n", ref)
+			return nil
+		}
+	}
+
+	existing := getLocalImageIDs(cli)
+	if mirrorRef := getMirrorRef(i, ref); mirrorRef != "" {
+		err = pullWithRetries(pullCtx, cli, i, mirrorRef, timeout)
+		if err == nil {
+			return adoptMirrorImage(cli, mirrorRef, ref, existing)
+		}
+		if pullCtx.Err() != nil {
+			return err
+		}
+		log.Printf("Unable to pull from the mirror, falling back to %s: %s\n", ref, err)
+	}
+
+	err = pullWithRetries(pullCtx, cli, i, ref, timeout)
+	if err != nil {
+		return err
+	}
+	trackImage(cli, ref, existing)
+	return nil
+}
+
+// pullWithRetries pulls ref from the registry it names
+func pullWithRetries(pullCtx context.Context, cli *client.Client, i *t.Instance, ref string, timeout time.Duration) error {
+	err := checkInsecureRegistry(cli, i, ref)
+	if err != nil {
+		return err
+	}
+	registryAuth, err := getRegistryAuth(i, ref)
+	if err != nil {
+		return err
+	}
+	err = checkPullSpace(cli, i, ref)
+	if err != nil {
+		return err
+	}
+
+	retries := i.GetPropInt(t.PropDockerPullRetries)
+	backoff := i.GetPropDuration(t.PropDockerPullRetryBackoffSecs)
+	for attempt := 0; ; attempt++ {
+		err = pullOnce(pullCtx, cli, ref, registryAuth)
+		if err == nil {
+			log.Println("Image pull complete")
+			return nil
+		}
+		if pullCtx.Err() != nil {
+			return fmt.Errorf("ABORT: Unable to pull %s within %s: %s", ref, timeout, err)
+		}
+		if attempt >= retries || !isRetryablePullError(err) {
+			return fmt.Errorf("ABORT: Unable to pull %s: %s", ref, err)
+		}
+		log.Printf("Pull attempt %d of %d failed: %s. Retrying in %s\n", attempt+1, retries+1, err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-pullCtx.Done():
+			return fmt.Errorf("ABORT: Unable to pull %s within %s: %s", ref, timeout, err)
+		}
+		backoff *= 2
+	}
+}
+
+func pullOnce(pullCtx context.Context, cli *client.Client, ref, registryAuth string) error {
+	log.Printf("Pulling %q from the registry...\n", ref)
+	resp, err := cli.ImagePull(pullCtx, ref, types.ImagePullOptions{RegistryAuth: registryAuth})
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+	_, err = readProgress(resp, "Pull")
+	return err
+}
+
+// isRetryablePullError tells transient failures apart from those that a retry cannot fix
+func isRetryablePullError(err error) bool {
+	if client.IsErrUnauthorized(err) || client.IsErrImageNotFound(err) {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, permanent := range []string{"unauthorized", "authentication required", "denied", "not found", "manifest unknown", "invalid reference"} {
+		if strings.Contains(msg, permanent) {
+			return false
+		}
+	}
+	return true
+}
+
+// lockImageRef takes an exclusive lock keyed by image ref, shared by all deployer processes on
+// the host. It reports whether it had to wait for another process to release the lock.
+func lockImageRef(lockCtx context.Context, ref string) (unlock func(), waited bool, err error) {
+	err = os.MkdirAll(pullLockDir, 0755)
+	if err != nil {
+		return nil, false, err
+	}
+	lockPath := filepath.Join(pullLockDir, fmt.Sprintf("pull-%x.lock", sha256.Sum256([]byte(ref))))
+	return lockFile(lockCtx, lockPath, "Another deployer process is pulling "+ref)
+}
+
+// lockFile takes an exclusive flock on path, polling until it is available or lockCtx is done.
+// It reports whether it had to wait for another process to release the lock.
+func lockFile(lockCtx context.Context, path, waitMsg string) (unlock func(), waited bool, err error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for {
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if err != syscall.EWOULDBLOCK {
+			f.Close()
+			return nil, false, err
+		}
+		if !waited {
+			log.Println(waitMsg + ", waiting for it to finish")
+			waited = true
+		}
+		select {
+		case <-time.After(time.Second):
+		case <-lockCtx.Done():
+			f.Close()
+			return nil, waited, lockCtx.Err()
+		}
+	}
+
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, waited, nil
+}
@@ -0,0 +1,78 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+func TestIsRetryablePullError(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int // status of the daemon response, or 0 to use err
+		message   string
+		err       error
+		retryable bool
+	}{
+		{name: "daemon unauthorized", status: http.StatusUnauthorized, message: "authentication required"},
+		{name: "registry denied", status: http.StatusInternalServerError, message: "pull access denied for private/app, repository does not exist or may require 'docker login'"},
+		{name: "registry unauthorized", status: http.StatusInternalServerError, message: "Get https://registry/v2/app/manifests/1: unauthorized: authentication required"},
+		{name: "tag not found", status: http.StatusNotFound, message: "manifest for app:missing not found"},
+		{name: "manifest unknown", status: http.StatusInternalServerError, message: "manifest unknown: manifest unknown"},
+		{name: "invalid reference", status: http.StatusBadRequest, message: "invalid reference format"},
+		{name: "registry timeout", status: http.StatusInternalServerError, message: "Get https://registry/v2/: net/http: request canceled while waiting for connection", retryable: true},
+		{name: "registry unavailable", status: http.StatusInternalServerError, message: "received unexpected HTTP status: 503 Service Unavailable", retryable: true},
+		{name: "connection reset", err: errors.New("read tcp 10.0.0.1:443: connection reset by peer"), retryable: true},
+		{name: "stream ended", err: errors.New("unexpected EOF"), retryable: true},
+	}
+	for _, test := range tests {
+		err := test.err
+		if test.status != 0 {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(test.status)
+				fmt.Fprintf(w, `{"message":%q}`, test.message)
+			}))
+			cli, cliErr := client.NewClient("tcp://"+strings.TrimPrefix(server.URL, "http://"), "1.25", nil, nil)
+			if cliErr != nil {
+				t.Fatal(cliErr)
+			}
+			_, err = cli.ImagePull(ctx, "app:1", types.ImagePullOptions{})
+			server.Close()
+			if err == nil {
+				t.Fatalf("%s: no error from the daemon", test.name)
+			}
+		}
+		if got := isRetryablePullError(err); got != test.retryable {
+			t.Errorf("%s: isRetryablePullError(%q) = %v, want %v", test.name, err, got, test.retryable)
+		}
+	}
+}
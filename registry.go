@@ -0,0 +1,224 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	t "github.com/claudiobernardoromao/docker-img-deployer/types"
+	"github.com/docker/docker/api/types"
+)
+
+const dockerHubRegistry = "docker.io"
+const dockerHubServerAddress = "https://index.docker.io/v1/"
+
+// splitImageRef splits an image reference into its registry host and the repository path
+// that follows it, applying the same defaulting rules as the Docker client
+func splitImageRef(ref string) (registry, remainder string) {
+	n := strings.Index(ref, "/")
+	if n == -1 {
+		return dockerHubRegistry, ref
+	}
+	first := ref[:n]
+	if !strings.ContainsAny(first, ".:") && first != "localhost" {
+		return dockerHubRegistry, ref
+	}
+	return normalizeRegistryHost(first), ref[n+1:]
+}
+
+// normalizeRegistryHost turns a registry server address into a bare host name
+func normalizeRegistryHost(address string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(address, "https://"), "http://")
+	if n := strings.Index(host, "/"); n != -1 {
+		host = host[:n]
+	}
+	switch host {
+	case "index.docker.io", "registry-1.docker.io":
+		return dockerHubRegistry
+	}
+	return host
+}
+
+// getRegistryAuth returns the encoded credentials to pull ref, or an empty string to pull anonymously
+func getRegistryAuth(i *t.Instance, ref string) (string, error) {
+	registry, _ := splitImageRef(ref)
+	auth, source, err := findRegistryCredentials(i, registry)
+	if err != nil || auth == nil {
+		return "", err
+	}
+	log.Printf("Using credentials from %s for registry %s\n", source, registry)
+
+	auth.ServerAddress = registry
+	if registry == dockerHubRegistry {
+		auth.ServerAddress = dockerHubServerAddress
+	}
+	b, err := json.Marshal(auth)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(b), nil
+}
+
+// findRegistryCredentials looks for credentials for a registry in the Custom Properties,
+// the host credentials file and the Docker client config file, in that order. The Custom
+// Properties only hold credentials for the registry of DockerImageName.
+func findRegistryCredentials(i *t.Instance, registry string) (*types.AuthConfig, string, error) {
+	username := i.GetPropString(t.PropDockerRegistryUsername)
+	if username != "" && isImageRegistry(i, registry) {
+		password, err := decryptPropValue(i, i.GetPropString(t.PropDockerRegistryPassword))
+		if err != nil {
+			return nil, "", fmt.Errorf("ABORT: %s could not be decrypted: %s", t.PropDockerRegistryPassword, err)
+		}
+		return &types.AuthConfig{Username: username, Password: password}, "Custom Properties", nil
+	}
+
+	credentialsFile := i.GetPropValid(t.PropDockerRegistryCredentialsFile)
+	var credentials map[string]t.RegistryCredentials
+	found, err := readJSONFileIfExists(credentialsFile, &credentials)
+	if err != nil {
+		return nil, "", err
+	}
+	if found {
+		warnIfGroupOrWorldReadable(credentialsFile)
+		for address, c := range credentials {
+			if normalizeRegistryHost(address) == registry {
+				return &types.AuthConfig{Username: c.Username, Password: c.Password}, credentialsFile, nil
+			}
+		}
+	}
+
+	configFile := i.GetPropValid(t.PropDockerRegistryConfigFile)
+	var config t.DockerConfig
+	found, err = readJSONFileIfExists(configFile, &config)
+	if err != nil {
+		return nil, "", err
+	}
+	if found {
+		for address, a := range config.Auths {
+			if normalizeRegistryHost(address) != registry {
+				continue
+			}
+			auth := &types.AuthConfig{Username: a.Username, Password: a.Password, IdentityToken: a.IdentityToken}
+			if a.Auth != "" {
+				b, err := base64.StdEncoding.DecodeString(a.Auth)
+				if err != nil {
+					return nil, "", fmt.Errorf("Invalid auth for %s in %s: %s", address, configFile, err)
+				}
+				parts := strings.SplitN(string(b), ":", 2)
+				if len(parts) != 2 {
+					return nil, "", fmt.Errorf("Invalid auth for %s in %s", address, configFile)
+				}
+				auth.Username, auth.Password = parts[0], parts[1]
+			}
+			return auth, configFile, nil
+		}
+	}
+
+	return nil, "", nil
+}
+
+// isImageRegistry tells whether registry is the registry of the DockerImageName of the instance
+func isImageRegistry(i *t.Instance, registry string) bool {
+	repo := i.GetPropString(t.PropDockerImageName)
+	if repo == "" {
+		return false
+	}
+	imageRegistry, _ := splitImageRef(repo)
+	return imageRegistry == registry
+}
+
+// readJSONFileIfExists unmarshals a JSON file into v. It returns false if the file does not exist.
+func readJSONFileIfExists(path string, v interface{}) (bool, error) {
+	if path == "" {
+		return false, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	err = json.Unmarshal(b, v)
+	if err != nil {
+		return false, fmt.Errorf("Invalid JSON in %s: %s", path, err)
+	}
+	return true, nil
+}
+
+func warnIfGroupOrWorldReadable(path string) {
+	fi, err := os.Stat(path)
+	if err == nil && fi.Mode().Perm()&0077 != 0 {
+		log.Printf("WARNING: %s holds credentials but is accessible by other users (mode %#o)\n", path, fi.Mode().Perm())
+	}
+}
+
+// decryptPropValue decrypts a base64 Custom Property value encrypted with AES-CBC
+// using the platform's claim encryption key and IV
+func decryptPropValue(i *t.Instance, value string) (string, error) {
+	if value == "" {
+		return "", errors.New("value is empty")
+	}
+	key, err := base64.StdEncoding.DecodeString(i.Platform.ClaimEncryptionKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid platform encryption key: %s", err)
+	}
+	iv, err := base64.StdEncoding.DecodeString(i.Platform.ClaimEncryptionIv)
+	if err != nil {
+		return "", fmt.Errorf("invalid platform encryption IV: %s", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return "", fmt.Errorf("value is not base64 encoded: %s", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	if len(iv) != block.BlockSize() {
+		return "", errors.New("invalid platform encryption IV length")
+	}
+	if len(ciphertext) == 0 || len(ciphertext)%block.BlockSize() != 0 {
+		return "", errors.New("value is not a whole number of cipher blocks")
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+
+	// Remove PKCS#7 padding
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > block.BlockSize() || !bytes.HasSuffix(plaintext, bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return "", errors.New("invalid padding, the value was not encrypted with the platform key")
+	}
+	return string(plaintext[:len(plaintext)-padding]), nil
+}
@@ -0,0 +1,196 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+
+	t "github.com/claudiobernardoromao/docker-img-deployer/types"
+	"github.com/docker/docker/api/types"
+)
+
+// Default directories of trusted keys and detached signatures
+const defaultSigningKeysDir = "/etc/apprenda/docker-signing-keys"
+const defaultSignaturesDir = "/etc/apprenda/docker-signatures"
+
+// signatureRequired tells whether the policy requires a signed image for the instance
+func signatureRequired(i *t.Instance, sp *t.SignaturePolicy) bool {
+	matches := func(values []string, s string) bool {
+		return containsFold(values, "*") || containsFold(values, s)
+	}
+	return matches(sp.Stages, i.Workload.Stage.Value) || matches(sp.Tenants, i.TenantAlias())
+}
+
+// getSignedDigest returns the digest a signature must cover: the registry digest of the image if
+// it came from a registry, or its image ID if it was loaded or built locally
+func getSignedDigest(ref string, img types.ImageInspect) string {
+	if repoDigest := getRepoDigest(ref, img.RepoDigests); repoDigest != "" {
+		return repoDigest[strings.Index(repoDigest, "@")+1:]
+	}
+	return img.ID
+}
+
+// verifyImageSignature aborts the deployment if the host image policy requires a signed image
+// and no trusted key verifies the detached signature of its digest
+func verifyImageSignature(i *t.Instance, ref string, img types.ImageInspect) error {
+	policy, err := loadImagePolicy()
+	if err != nil || policy == nil || policy.RequireSignature == nil {
+		return err
+	}
+	sp := policy.RequireSignature
+	if !signatureRequired(i, sp) {
+		return nil
+	}
+	digest := getSignedDigest(ref, img)
+
+	keysDir := sp.KeysDir
+	if keysDir == "" {
+		keysDir = defaultSigningKeysDir
+	}
+	keys, err := loadPublicKeys(keysDir)
+	if err != nil {
+		return fmt.Errorf("ABORT: Unable to load signing keys from %s: %s", keysDir, err)
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("ABORT: Image %s must be signed but there are no signing keys in %s", ref, keysDir)
+	}
+
+	sigPaths, err := getSignaturePaths(i, sp, digest)
+	if err != nil {
+		return err
+	}
+	return verifySignatures(ref, digest, keys, sigPaths)
+}
+
+// verifySignatures checks the signatures at sigPaths against the trusted keys until one verifies.
+// Every candidate is tried, so an invalid signature in the archive does not hide a valid one
+// provided by the operators.
+func verifySignatures(ref, digest string, keys map[string]crypto.PublicKey, sigPaths []string) error {
+	var failures []string
+	for _, sigPath := range sigPaths {
+		sig, err := readSignature(sigPath)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("unable to read %s: %s", sigPath, err))
+			continue
+		}
+		for name, key := range keys {
+			if verifySignature(key, []byte(digest), sig) {
+				log.Printf("Image %s (%s) signature %s verified with key %s\n", ref, digest, sigPath, name)
+				return nil
+			}
+		}
+		failures = append(failures, fmt.Sprintf("%s is not valid for any trusted key", sigPath))
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("ABORT: Image %s (%s) must be signed but no signature is valid: %s", ref, digest, strings.Join(failures, "; "))
+	}
+	return fmt.Errorf("ABORT: Image %s (%s) must be signed but no signature was found in %s", ref, digest, strings.Join(sigPaths, " or "))
+}
+
+// getSignaturePaths lists where the signature of digest may be, in order of preference
+func getSignaturePaths(i *t.Instance, sp *t.SignaturePolicy, digest string) ([]string, error) {
+	var paths []string
+	if name := i.GetPropString(t.PropDockerImageSignature); name != "" {
+		archiveSrcDir := getArchiveSrcDir(i)
+		sigPath := filepath.Join(archiveSrcDir, name)
+		if rel, err := filepath.Rel(archiveSrcDir, sigPath); err != nil || strings.HasPrefix(rel, "..") {
+			return nil, fmt.Errorf("ABORT: DockerImageSignature %s must be inside the component folder of the archive", name)
+		}
+		paths = append(paths, sigPath)
+	}
+	sigDir := sp.SignaturesDir
+	if sigDir == "" {
+		sigDir = defaultSignaturesDir
+	}
+	return append(paths, filepath.Join(sigDir, strings.Replace(digest, ":", "-", 1)+".sig")), nil
+}
+
+// loadPublicKeys reads the PEM encoded public keys in dir, keyed by file name
+func loadPublicKeys(dir string) (map[string]crypto.PublicKey, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(b)
+		if block == nil {
+			return nil, fmt.Errorf("%s is not PEM encoded", file)
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+		keys[filepath.Base(file)] = key
+	}
+	return keys, nil
+}
+
+// readSignature reads a detached signature, either raw or base64 encoded
+func readSignature(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b))); err == nil {
+		return sig, nil
+	}
+	return b, nil
+}
+
+// verifySignature checks a SHA-256 RSA PKCS#1 v1.5 or ECDSA signature of message
+func verifySignature(key crypto.PublicKey, message, sig []byte) bool {
+	hash := sha256.Sum256(message)
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, hash[:], sig) == nil
+	case *ecdsa.PublicKey:
+		var rs struct{ R, S *big.Int }
+		if rest, err := asn1.Unmarshal(sig, &rs); err != nil || len(rest) > 0 {
+			return false
+		}
+		return ecdsa.Verify(k, hash[:], rs.R, rs.S)
+	}
+	return false
+}
@@ -0,0 +1,211 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// signingKeys are the test keys, generated once
+var signingKeys struct {
+	rsa   *rsa.PrivateKey
+	ecdsa *ecdsa.PrivateKey
+}
+
+func getSigningKeys(t *testing.T) (*rsa.PrivateKey, *ecdsa.PrivateKey) {
+	if signingKeys.rsa == nil {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signingKeys.rsa, signingKeys.ecdsa = rsaKey, ecdsaKey
+	}
+	return signingKeys.rsa, signingKeys.ecdsa
+}
+
+// sign returns the signature of message by key, in the format expected by verifySignature
+func sign(t *testing.T, key crypto.Signer, message string) []byte {
+	hash := sha256.Sum256([]byte(message))
+	if k, ok := key.(*ecdsa.PrivateKey); ok {
+		r, s, err := ecdsa.Sign(rand.Reader, k, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	}
+	sig, err := key.Sign(rand.Reader, hash[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+func TestVerifySignature(t *testing.T) {
+	const digest = "sha256:0123"
+	rsaKey, ecdsaKey := getSigningKeys(t)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		key   crypto.PublicKey
+		sig   []byte
+		valid bool
+	}{
+		{"rsa", &rsaKey.PublicKey, sign(t, rsaKey, digest), true},
+		{"ecdsa", &ecdsaKey.PublicKey, sign(t, ecdsaKey, digest), true},
+		{"rsa other digest", &rsaKey.PublicKey, sign(t, rsaKey, "sha256:4567"), false},
+		{"ecdsa other digest", &ecdsaKey.PublicKey, sign(t, ecdsaKey, "sha256:4567"), false},
+		{"ecdsa other key", &otherKey.PublicKey, sign(t, ecdsaKey, digest), false},
+		{"ecdsa signature for rsa key", &rsaKey.PublicKey, sign(t, ecdsaKey, digest), false},
+		{"rsa signature for ecdsa key", &ecdsaKey.PublicKey, sign(t, rsaKey, digest), false},
+		{"ecdsa trailing data", &ecdsaKey.PublicKey, append(sign(t, ecdsaKey, digest), 0), false},
+		{"empty", &rsaKey.PublicKey, nil, false},
+		{"unsupported key", "key", sign(t, rsaKey, digest), false},
+	}
+	for _, test := range tests {
+		if got := verifySignature(test.key, []byte(digest), test.sig); got != test.valid {
+			t.Errorf("%s: verifySignature() = %v, want %v", test.name, got, test.valid)
+		}
+	}
+}
+
+func TestReadSignature(t *testing.T) {
+	raw := []byte{0x30, 0x45, 0x02, 0x21, 0xff, 0x00, '\n'}
+	tests := []struct {
+		name    string
+		content []byte
+		want    []byte
+	}{
+		{"raw", raw, raw},
+		{"base64", []byte(base64.StdEncoding.EncodeToString(raw)), raw},
+		{"base64 with newline", []byte(base64.StdEncoding.EncodeToString(raw) + "\n"), raw},
+		{"not base64", []byte("sig!"), []byte("sig!")},
+	}
+	dir, err := ioutil.TempDir("", "signature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, test := range tests {
+		path := filepath.Join(dir, "test.sig")
+		if err := ioutil.WriteFile(path, test.content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := readSignature(path); err != nil || string(got) != string(test.want) {
+			t.Errorf("%s: readSignature() = %x, %v, want %x", test.name, got, err, test.want)
+		}
+	}
+	if _, err := readSignature(filepath.Join(dir, "missing.sig")); !os.IsNotExist(err) {
+		t.Errorf("missing: readSignature() error = %v", err)
+	}
+}
+
+func TestVerifySignatures(t *testing.T) {
+	const digest = "sha256:0123"
+	rsaKey, ecdsaKey := getSigningKeys(t)
+	dir, err := ioutil.TempDir("", "signature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Trusted keys are written as PEM files and read back as the deployer does
+	for name, key := range map[string]crypto.PublicKey{"rsa.pem": &rsaKey.PublicKey, "ecdsa.pem": &ecdsaKey.PublicKey} {
+		b, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: b}), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys, err := loadPublicKeys(dir)
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("loadPublicKeys() = %v, %v", keys, err)
+	}
+	untrusted, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigs := map[string][]byte{
+		"rsa.sig":       sign(t, rsaKey, digest),
+		"ecdsa.sig":     []byte(base64.StdEncoding.EncodeToString(sign(t, ecdsaKey, digest))),
+		"untrusted.sig": sign(t, untrusted, digest),
+		"other.sig":     sign(t, rsaKey, "sha256:4567"),
+	}
+	for name, sig := range sigs {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), sig, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		paths []string
+		err   string // expected part of the error, or empty if the image is verified
+	}{
+		{"rsa", []string{"rsa.sig"}, ""},
+		{"ecdsa base64", []string{"ecdsa.sig"}, ""},
+		{"invalid archive signature, valid operator signature", []string{"untrusted.sig", "rsa.sig"}, ""},
+		{"missing archive signature", []string{"missing.sig", "ecdsa.sig"}, ""},
+		{"untrusted key", []string{"untrusted.sig"}, "no signature is valid"},
+		{"other digest", []string{"other.sig", "missing.sig"}, "no signature is valid"},
+		{"not found", []string{"missing.sig", "missing2.sig"}, "no signature was found"},
+		{"unreadable", []string{"."}, "unable to read"},
+	}
+	for _, test := range tests {
+		var paths []string
+		for _, path := range test.paths {
+			paths = append(paths, filepath.Join(dir, path))
+		}
+		err := verifySignatures("app:1", digest, keys, paths)
+		if (test.err == "") != (err == nil) || err != nil && !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: verifySignatures() = %v, want %q", test.name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	t "github.com/claudiobernardoromao/docker-img-deployer/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+)
+
+// Label holding a hash of the spec a container was created from
+const labelConfigHash = "com.apprenda.deployer.config-hash"
+
+// containerSpec holds everything needed to create the instance container
+type containerSpec struct {
+	Name             string                    `json:"name"`
+	Image            string                    `json:"image"`
+	Config           *container.Config         `json:"config"`
+	HostConfig       *container.HostConfig     `json:"hostConfig"`
+	NetworkingConfig *network.NetworkingConfig `json:"networkingConfig"`
+	NetworkName      string                    `json:"networkName,omitempty"`
+	NetworkScope     string                    `json:"networkScope,omitempty"`
+	Binds            []bindMount               `json:"binds"`
+}
+
+// buildContainerSpec parses the instance's custom properties into a container spec.
+// It has no side effects on the filesystem or the Docker daemon.
+func buildContainerSpec(i *t.Instance) (*containerSpec, error) {
+	ref, err := getImageRef(i)
+	if err != nil {
+		return nil, err
+	}
+	ref = getPinnedRef(i, ref)
+
+	ports, portBindings, err := parseInstancePorts(i)
+	if err != nil {
+		return nil, err
+	}
+
+	env := getContainerEnv(i)
+
+	config := &container.Config{
+		Image:        ref,
+		ExposedPorts: ports,
+		Env:          env,
+		AttachStdin:  false,
+		AttachStdout: false,
+		AttachStderr: false,
+		Tty:          false,
+	}
+
+	cmd, err := i.GetPropCommand(t.PropDockerCmd)
+	if err != nil {
+		return nil, fmt.Errorf("ABORT: Invalid %s: %s", t.PropDockerCmd, err)
+	}
+	if len(cmd) > 0 {
+		config.Cmd = cmd
+	}
+
+	entrypoint, err := i.GetPropCommand(t.PropDockerEntrypoint)
+	if err != nil {
+		return nil, fmt.Errorf("ABORT: Invalid %s: %s", t.PropDockerEntrypoint, err)
+	}
+	if len(entrypoint) > 0 {
+		config.Entrypoint = entrypoint
+	}
+
+	binds, err := getBindMounts(i)
+	if err != nil {
+		return nil, err
+	}
+	bindSpecs := []string{}
+	for _, bind := range binds {
+		bindSpecs = append(bindSpecs, bind.Spec)
+	}
+
+	resources := container.Resources{}
+	if i.Resource.ResourcePolicy.MemoryLimit > 0 {
+		resources.Memory = i.Resource.ResourcePolicy.MemoryLimit * 1024 * 1024
+	}
+	if i.Resource.ResourcePolicy.CPULimit > 0 {
+		resources.CPUShares = i.Resource.ResourcePolicy.CPULimit
+	}
+
+	networkName := i.GetPropString(t.PropDockerNetwork)
+	networkingConfig := &network.NetworkingConfig{}
+
+	networkScope := strings.ToLower(i.GetPropEnum(t.PropDockerNetworkScope))
+	if networkScope != "" {
+		networkName = getScopedNetworkName(i)
+
+		netAlias := i.Workload.BundleName
+		if networkScope != "app" {
+			netAlias = strings.Join([]string{i.Workload.ApplicationAlias, i.Workload.VersionAlias, i.Workload.BundleName}, "-")
+		}
+		networkingConfig = &network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{
+				networkName: {
+					Aliases:   []string{netAlias},
+					NetworkID: networkName,
+				},
+			},
+		}
+	}
+
+	hostConfig := &container.HostConfig{
+		Binds:        bindSpecs,
+		Resources:    resources,
+		PortBindings: portBindings,
+		NetworkMode:  container.NetworkMode(networkName),
+	}
+
+	spec := &containerSpec{
+		Name:             i.ContainerName(),
+		Image:            ref,
+		Config:           config,
+		HostConfig:       hostConfig,
+		NetworkingConfig: networkingConfig,
+		NetworkName:      networkName,
+		NetworkScope:     networkScope,
+		Binds:            binds,
+	}
+	spec.setLabel(labelManaged, "true")
+	spec.setLabel(labelImage, ref)
+	spec.setLabel(labelConfigHash, spec.configHash())
+	return spec, nil
+}
+
+// setImage switches the spec to another reference of its image
+func (spec *containerSpec) setImage(ref string) {
+	spec.Image = ref
+	spec.Config.Image = ref
+	spec.setLabel(labelImage, ref)
+	spec.setLabel(labelConfigHash, spec.configHash())
+}
+
+func (spec *containerSpec) setLabel(key, value string) {
+	if spec.Config.Labels == nil {
+		spec.Config.Labels = map[string]string{}
+	}
+	spec.Config.Labels[key] = value
+}
+
+// configHash returns a hash of everything that defines the container, so that
+// an existing container can be checked for drift against a freshly built spec
+func (spec *containerSpec) configHash() string {
+	config := *spec.Config
+	// Platform tokens come from a map, so the order of the environment is not significant
+	config.Env = append([]string{}, config.Env...)
+	sort.Strings(config.Env)
+	config.Labels = map[string]string{}
+	for key, value := range spec.Config.Labels {
+		if key != labelConfigHash {
+			config.Labels[key] = value
+		}
+	}
+
+	b, err := json.Marshal(struct {
+		Name             string
+		Config           *container.Config
+		HostConfig       *container.HostConfig
+		NetworkingConfig *network.NetworkingConfig
+	}{spec.Name, &config, spec.HostConfig, spec.NetworkingConfig})
+	if err != nil {
+		// Only unsupported types fail to marshal, and the Docker API types have none
+		panic(err)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(b))
+}
+
+// dockerRunArgs renders the spec as an equivalent `docker run` argument list
+func (spec *containerSpec) dockerRunArgs() []string {
+	args := []string{"docker", "run", "-d", "--name", spec.Name}
+
+	for _, env := range spec.Config.Env {
+		args = append(args, "-e", env)
+	}
+
+	ports := []string{}
+	for port, bindings := range spec.HostConfig.PortBindings {
+		for _, binding := range bindings {
+			hostPort := binding.HostPort
+			if binding.HostIP != "" {
+				hostPort = binding.HostIP + ":" + hostPort
+			}
+			ports = append(ports, hostPort+":"+string(port))
+		}
+	}
+	sort.Strings(ports)
+	for _, port := range ports {
+		args = append(args, "-p", port)
+	}
+
+	for _, bind := range spec.HostConfig.Binds {
+		args = append(args, "-v", bind)
+	}
+
+	if spec.NetworkName != "" {
+		args = append(args, "--network", spec.NetworkName)
+		if endpoint, ok := spec.NetworkingConfig.EndpointsConfig[spec.NetworkName]; ok {
+			for _, alias := range endpoint.Aliases {
+				args = append(args, "--network-alias", alias)
+			}
+		}
+	}
+
+	if spec.HostConfig.Memory > 0 {
+		args = append(args, "--memory", strconv.FormatInt(spec.HostConfig.Memory, 10))
+	}
+	if spec.HostConfig.CPUShares > 0 {
+		args = append(args, "--cpu-shares", strconv.FormatInt(spec.HostConfig.CPUShares, 10))
+	}
+
+	// docker run only accepts the executable in --entrypoint, any further
+	// entrypoint arguments are passed ahead of the command
+	cmd := []string(spec.Config.Cmd)
+	if len(spec.Config.Entrypoint) > 0 {
+		args = append(args, "--entrypoint", spec.Config.Entrypoint[0])
+		cmd = append(append([]string{}, spec.Config.Entrypoint[1:]...), cmd...)
+	}
+
+	args = append(args, spec.Image)
+	return append(args, cmd...)
+}
+
+// dockerRunCommand renders the spec as a shell-quoted `docker run` command line
+func (spec *containerSpec) dockerRunCommand() string {
+	args := spec.dockerRunArgs()
+	quoted := make([]string, len(args))
+	for n, arg := range args {
+		quoted[n] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// shellQuote quotes a string for safe use as a single POSIX shell word
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	if strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=@,+%", r))
+	}) == -1 {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
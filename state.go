@@ -0,0 +1,151 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	t "github.com/claudiobernardoromao/docker-img-deployer/types"
+	"github.com/docker/docker/client"
+)
+
+const stateFileName = "docker-state.json"
+
+func getStatePath(i *t.Instance) string {
+	return filepath.Join(i.Host.Root, i.Workload.InstanceID, stateFileName)
+}
+
+// loadState reads the instance state file. It returns nil without an error if
+// the instance was deployed by a deployer version that did not record state.
+func loadState(i *t.Instance) (*t.State, error) {
+	b, err := ioutil.ReadFile(getStatePath(i))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var st t.State
+	err = json.Unmarshal(b, &st)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid state file %s: %s", getStatePath(i), err)
+	}
+	return &st, nil
+}
+
+// saveState appends an event to the audit trail and writes the instance state file
+func saveState(i *t.Instance, st *t.State, event, details string) error {
+	st.History = append(st.History, t.StateEvent{
+		Time:    time.Now().UTC(),
+		Event:   event,
+		Details: details,
+	})
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(getStatePath(i), b, 0644)
+	if err != nil {
+		return err
+	}
+	log.Printf("Recorded %q in %s\n", event, getStatePath(i))
+	return nil
+}
+
+// newState records the container created from spec, keeping the history of any earlier deployment attempt
+func newState(cli *client.Client, i *t.Instance, spec *containerSpec, containerID string) *t.State {
+	st := &t.State{
+		DeployerVersion: version,
+		ContainerID:     containerID,
+		ContainerName:   spec.Name,
+		Image:           spec.Image,
+	}
+	if prev, err := loadState(i); err == nil && prev != nil {
+		st.History = prev.History
+	}
+
+	img, _, err := cli.ImageInspectWithRaw(ctx, spec.Image)
+	if err != nil {
+		log.Println("Unable to inspect image:", err)
+	} else {
+		st.ImageID = img.ID
+		st.ImageDigest = getRepoDigest(spec.Image, img.RepoDigests)
+	}
+
+	if spec.NetworkName != "" {
+		st.Networks = []t.StateNetwork{{Name: spec.NetworkName, Scoped: spec.NetworkScope != ""}}
+	}
+	for _, bind := range spec.Binds {
+		st.Binds = append(st.Binds, t.StateBind{
+			Type:          bind.Type,
+			HostPath:      bind.HostPath,
+			ContainerPath: bind.ContainerPath,
+		})
+	}
+	return st
+}
+
+// getRepoDigest picks the repo@digest reference matching the repository of ref
+func getRepoDigest(ref string, repoDigests []string) string {
+	repo := imageRepo(ref)
+	for _, digest := range repoDigests {
+		if strings.HasPrefix(digest, repo+"@") {
+			return digest
+		}
+	}
+	if len(repoDigests) > 0 {
+		return repoDigests[0]
+	}
+	return ""
+}
+
+// getStateOrDerived returns the recorded state of the instance, or one derived
+// from its current Custom Properties for instances deployed without a state file
+func getStateOrDerived(i *t.Instance) (*t.State, error) {
+	st, err := loadState(i)
+	if err != nil || st != nil {
+		return st, err
+	}
+	log.Println("No state file found, deriving container details from Custom Properties")
+	st = &t.State{ContainerName: i.ContainerName()}
+	st.Image, _ = getImageRef(i)
+	if i.GetPropEnum(t.PropDockerNetworkScope) != "" {
+		st.Networks = []t.StateNetwork{{Name: getScopedNetworkName(i), Scoped: true}}
+	}
+	return st, nil
+}
+
+// containerRef returns the most precise reference to the instance container
+func containerRef(st *t.State) string {
+	if st.ContainerID != "" {
+		return st.ContainerID
+	}
+	return st.ContainerName
+}
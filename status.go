@@ -0,0 +1,249 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	t "github.com/claudiobernardoromao/docker-img-deployer/types"
+	"github.com/docker/docker/client"
+)
+
+// containerStatus prints the container, network, bind and log forwarder state of the instance
+func containerStatus(i *t.Instance, args []string) error {
+	flags := flag.NewFlagSet("status", flag.ContinueOnError)
+	format := flags.String("format", "json", "Output format: json or table")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	cli, err := client.NewEnvClient()
+	if err != nil {
+		return err
+	}
+
+	s, err := getStatus(cli, i)
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "json":
+		b, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+	case "table":
+		printStatusTable(s)
+	default:
+		return fmt.Errorf("Unknown status format %q, expected json or table", *format)
+	}
+	return nil
+}
+
+func getStatus(cli *client.Client, i *t.Instance) (*t.Status, error) {
+	s := &t.Status{
+		InstanceID:    i.Workload.InstanceID,
+		ContainerName: i.ContainerName(),
+		Binds:         getBindStatus(i),
+		LogForwarder:  getForwarderStatus(i),
+		Readiness:     getReadinessResult(i),
+	}
+	s.Image, _ = getImageRef(i)
+
+	// Prefer what deploy recorded over the current Custom Properties
+	st, err := loadState(i)
+	if err != nil {
+		return nil, err
+	}
+	ref := i.ContainerName()
+	if st != nil {
+		s.State = st
+		s.Image = st.Image
+		ref = containerRef(st)
+	}
+
+	c, err := cli.ContainerInspect(ctx, ref)
+	if err != nil && !client.IsErrContainerNotFound(err) {
+		return nil, err
+	}
+	if err == nil {
+		s.Container = t.ContainerStatus{
+			Exists: true,
+			ID:     c.ID,
+			Image:  c.Image,
+		}
+		if c.Config != nil {
+			s.Container.Image = c.Config.Image
+		}
+		if c.State != nil {
+			s.Container.State = c.State.Status
+			s.Container.Running = c.State.Running
+			s.Container.Pid = c.State.Pid
+			s.Container.ExitCode = c.State.ExitCode
+			s.Container.Error = c.State.Error
+			s.Container.StartedAt = c.State.StartedAt
+			s.Container.FinishedAt = c.State.FinishedAt
+			if c.State.Health != nil {
+				s.Container.Health = c.State.Health.Status
+			}
+		}
+	}
+
+	networkName := i.GetPropString(t.PropDockerNetwork)
+	if i.GetPropEnum(t.PropDockerNetworkScope) != "" {
+		networkName = getScopedNetworkName(i)
+	}
+	if st != nil {
+		networkName = ""
+		if len(st.Networks) > 0 {
+			networkName = st.Networks[0].Name
+		}
+	}
+	if networkName != "" {
+		s.Network = &t.NetworkStatus{Name: networkName}
+		n, err := cli.NetworkInspect(ctx, networkName)
+		if err != nil && !client.IsErrNetworkNotFound(err) {
+			return nil, err
+		}
+		if err == nil {
+			s.Network.Exists = true
+			s.Network.ID = n.ID
+			s.Network.Driver = n.Driver
+			s.Network.Scope = n.Scope
+		}
+		if s.Container.Exists && c.NetworkSettings != nil {
+			_, s.Network.Connected = c.NetworkSettings.Networks[networkName]
+		}
+	}
+
+	return s, nil
+}
+
+// getBindStatus resolves the host directories bound for the instance without creating them
+func getBindStatus(i *t.Instance) []t.BindStatus {
+	binds := []t.BindStatus{}
+	mounts, err := getBindMounts(i)
+	if err != nil {
+		log.Println(err)
+		return binds
+	}
+	for _, m := range mounts {
+		binds = append(binds, t.BindStatus{
+			Type:          m.Type,
+			HostPath:      m.HostPath,
+			ContainerPath: m.ContainerPath,
+			Exists:        dirExists(m.HostPath),
+		})
+	}
+	return binds
+}
+
+func getForwarderStatus(i *t.Instance) t.ForwarderStatus {
+	fs := t.ForwarderStatus{PidFile: getLogForwarderPidPath(i)}
+	b, err := ioutil.ReadFile(fs.PidFile)
+	if err != nil {
+		return fs
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return fs
+	}
+	fs.Pid = pid
+	fs.Running = syscall.Kill(pid, 0) == nil
+	return fs
+}
+
+func getReadinessResult(i *t.Instance) *t.ReadinessResult {
+	b, err := ioutil.ReadFile(getReadinessResultPath(i))
+	if err != nil {
+		return nil
+	}
+	var result t.ReadinessResult
+	err = json.Unmarshal(b, &result)
+	if err != nil {
+		return nil
+	}
+	return &result
+}
+
+func dirExists(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
+
+func printStatusTable(s *t.Status) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintf(w, "INSTANCE\t%s\n", s.InstanceID)
+	fmt.Fprintf(w, "CONTAINER\t%s\n", s.ContainerName)
+	fmt.Fprintf(w, "IMAGE\t%s\n", s.Image)
+	if s.Container.Exists {
+		fmt.Fprintf(w, "CONTAINER ID\t%s\n", s.Container.ID)
+		fmt.Fprintf(w, "STATE\t%s (pid %d, exit code %d)\n", s.Container.State, s.Container.Pid, s.Container.ExitCode)
+		fmt.Fprintf(w, "STARTED AT\t%s\n", s.Container.StartedAt)
+		if s.Container.Health != "" {
+			fmt.Fprintf(w, "HEALTH\t%s\n", s.Container.Health)
+		}
+		if s.Container.Error != "" {
+			fmt.Fprintf(w, "ERROR\t%s\n", s.Container.Error)
+		}
+	} else {
+		fmt.Fprintf(w, "STATE\tnot created\n")
+	}
+	if s.Network != nil {
+		fmt.Fprintf(w, "NETWORK\t%s (exists: %t, connected: %t)\n", s.Network.Name, s.Network.Exists, s.Network.Connected)
+	}
+	for _, b := range s.Binds {
+		fmt.Fprintf(w, "BIND (%s)\t%s -> %s (exists: %t)\n", b.Type, b.HostPath, b.ContainerPath, b.Exists)
+	}
+	if s.LogForwarder.Pid > 0 {
+		fmt.Fprintf(w, "LOG FORWARDER\tpid %d (running: %t)\n", s.LogForwarder.Pid, s.LogForwarder.Running)
+	} else {
+		fmt.Fprintf(w, "LOG FORWARDER\tnot started\n")
+	}
+	if s.State != nil {
+		for _, e := range s.State.History {
+			fmt.Fprintf(w, "HISTORY\t%s %s %s\n", e.Time.Format("2006-01-02 15:04:05"), e.Event, e.Details)
+		}
+	}
+	if s.Readiness != nil {
+		result := "FAILED"
+		if s.Readiness.Passed {
+			result = "PASSED"
+		}
+		fmt.Fprintf(w, "READINESS\t%s %s after %d attempts at %s\n", result, s.Readiness.URL, s.Readiness.Attempts, s.Readiness.CheckedAt.Format("2006-01-02 15:04:05"))
+	}
+}
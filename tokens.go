@@ -0,0 +1,181 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	t "github.com/claudiobernardoromao/docker-img-deployer/types"
+)
+
+// tokenReplacer substitutes $#NAME#$ platform token placeholders in archive files, following
+// the TokenReplacement rules the platform applies to other Linux workloads
+type tokenReplacer struct {
+	i        *t.Instance
+	rootDir  string
+	replacer *strings.Replacer
+}
+
+func newTokenReplacer(i *t.Instance) *tokenReplacer {
+	var pairs []string
+	for key, value := range i.Token.Tokens {
+		pairs = append(pairs, "$#"+key+"#$", value)
+	}
+	return &tokenReplacer{
+		i:        i,
+		rootDir:  getArchiveSrcDir(i),
+		replacer: strings.NewReplacer(pairs...),
+	}
+}
+
+// shouldReplace tells whether tokens are replaced in the archive file at rel, a slash-separated
+// path relative to the component folder of the archive
+func (r *tokenReplacer) shouldReplace(rel string) bool {
+	tr := r.i.Workload.TokenReplacement
+	if tr.ReplaceDefaultFilePatterns {
+		for _, p := range tr.DefaultFilePatterns {
+			if matchFilePattern(p.Pattern, rel) {
+				return true
+			}
+		}
+	}
+	for _, rule := range tr.Replacements {
+		if !rule.IncludePlatformTokens {
+			continue
+		}
+		excluded := false
+		for _, p := range rule.ExcludePatterns {
+			if matchFilePattern(p.Pattern, rel) {
+				excluded = true
+				break
+			}
+		}
+		if excluded {
+			continue
+		}
+		for _, p := range rule.IncludePatterns {
+			if matchFilePattern(p.Pattern, rel) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// replaceInCopy replaces tokens in the files copied from sourceDir to destDir that match the rules,
+// except for the files at the relative paths in skip
+func (r *tokenReplacer) replaceInCopy(sourceDir, destDir string, skip map[string]bool) error {
+	if len(r.i.Token.Tokens) == 0 {
+		return nil
+	}
+	return filepath.Walk(sourceDir, func(source string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(r.rootDir, source)
+		if err != nil {
+			return err
+		}
+		if !r.shouldReplace(filepath.ToSlash(rel)) {
+			return nil
+		}
+		destRel, err := filepath.Rel(sourceDir, source)
+		if err != nil || skip[destRel] {
+			return err
+		}
+		dest := filepath.Join(destDir, destRel)
+		// Only rewrite the file that was copied, not whatever a link put in its place points to
+		destInfo, err := os.Lstat(dest)
+		if err != nil {
+			return err
+		}
+		if !destInfo.Mode().IsRegular() {
+			return fmt.Errorf("ABORT: %s is no longer a regular file", dest)
+		}
+		err = checkCopyTarget(dest, destDir)
+		if err != nil {
+			return err
+		}
+		b, err := ioutil.ReadFile(dest)
+		if err != nil {
+			return err
+		}
+		if !bytes.Contains(b, []byte("$#")) {
+			return nil
+		}
+		replaced := r.replacer.Replace(string(b))
+		if replaced == string(b) {
+			return nil
+		}
+		f, err := os.OpenFile(dest, os.O_WRONLY|os.O_TRUNC|syscall.O_NOFOLLOW, 0)
+		if err != nil {
+			return err
+		}
+		_, err = f.WriteString(replaced)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		log.Println("Replaced platform tokens in", dest)
+		return nil
+	})
+}
+
+// matchFilePattern matches a TokenReplacement file pattern against a slash-separated relative
+// path. Patterns without a slash match file names in any folder, and a leading **/ matches any
+// number of folders.
+func matchFilePattern(pattern, rel string) bool {
+	pattern = strings.TrimPrefix(strings.TrimPrefix(strings.Replace(pattern, "\\", "/", -1), "./"), "/")
+	if pattern == "" {
+		return false
+	}
+	if !strings.Contains(pattern, "/") {
+		matched, _ := path.Match(pattern, path.Base(rel))
+		return matched
+	}
+	if strings.HasPrefix(pattern, "**/") {
+		pattern = pattern[3:]
+		for {
+			if matched, _ := path.Match(pattern, rel); matched {
+				return true
+			}
+			n := strings.Index(rel, "/")
+			if n == -1 {
+				return false
+			}
+			rel = rel[n+1:]
+		}
+	}
+	matched, _ := path.Match(pattern, rel)
+	return matched
+}
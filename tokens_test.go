@@ -0,0 +1,151 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	dt "github.com/claudiobernardoromao/docker-img-deployer/types"
+)
+
+func TestMatchFilePattern(t *testing.T) {
+	tests := []struct {
+		pattern, rel string
+		want         bool
+	}{
+		{"*.conf", "nginx.conf", true},
+		{"*.conf", "etc/nginx/nginx.conf", true},
+		{"*.conf", "etc/nginx/nginx.conf.bak", false},
+		{"nginx.conf", "etc/nginx/nginx.conf", true},
+		{"etc/*.conf", "etc/a.conf", true},
+		{"etc/*.conf", "etc/nginx/a.conf", false},
+		{"etc/*.conf", "other/etc/a.conf", false},
+		{"/etc/*.conf", "etc/a.conf", true},
+		{"./etc/*.conf", "etc/a.conf", true},
+		{`etc\*.conf`, "etc/a.conf", true},
+		{"**/*.conf", "a.conf", true},
+		{"**/*.conf", "etc/nginx/a.conf", true},
+		{"**/nginx/*.conf", "etc/nginx/a.conf", true},
+		{"**/nginx/*.conf", "etc/other/a.conf", false},
+		{"", "a.conf", false},
+		{"[", "a.conf", false},
+	}
+	for _, test := range tests {
+		if got := matchFilePattern(test.pattern, test.rel); got != test.want {
+			t.Errorf("matchFilePattern(%q, %q) = %v, want %v", test.pattern, test.rel, got, test.want)
+		}
+	}
+}
+
+func TestReplaceInCopy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tokens")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source, dest := filepath.Join(dir, "source/html"), filepath.Join(dir, "dest")
+
+	var i dt.Instance
+	err = json.Unmarshal([]byte(`{
+		"workload": {"tokenReplacement": {
+			"replaceDefaultFilePatterns": true,
+			"defaultFilePatterns": [{"pattern": "*.conf"}],
+			"replacements": [
+				{"includePlatformTokens": true, "includePatterns": [{"pattern": "**/*.html"}], "excludePatterns": [{"pattern": "skip.html"}]},
+				{"includePlatformTokens": false, "includePatterns": [{"pattern": "*.txt"}]}
+			]
+		}},
+		"token": {"tokens": {"PORT_HTTP_80": "41000", "HOST": "node1"}}
+	}`), &i)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"site.conf":      "listen $#PORT_HTTP_80#$;",
+		"a/index.html":   "http://$#HOST#$:$#PORT_HTTP_80#$/ $#UNKNOWN#$",
+		"a/skip.html":    "$#HOST#$",
+		"notes.txt":      "$#HOST#$",
+		"kept.conf":      "$#HOST#$",
+		"no-tokens.conf": "plain",
+	}
+	want := map[string]string{
+		"site.conf":      "listen 41000;",
+		"a/index.html":   "http://node1:41000/ $#UNKNOWN#$",
+		"a/skip.html":    "$#HOST#$",
+		"notes.txt":      "$#HOST#$",
+		"kept.conf":      "$#HOST#$",
+		"no-tokens.conf": "plain",
+	}
+	for name, content := range files {
+		for _, root := range []string{source, dest} {
+			path := filepath.Join(root, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(path, []byte(content), 0640); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	r := newTokenReplacer(&i)
+	r.rootDir = filepath.Join(dir, "source")
+	err = r.replaceInCopy(source, dest, map[string]bool{"kept.conf": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range want {
+		b, err := ioutil.ReadFile(filepath.Join(dest, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != content {
+			t.Errorf("%s = %q, want %q", name, b, content)
+		}
+	}
+	if fi, err := os.Stat(filepath.Join(dest, "site.conf")); err != nil || fi.Mode().Perm() != 0640 {
+		t.Errorf("site.conf mode changed: %v %v", fi, err)
+	}
+
+	// A link planted in place of a copied file is not written through
+	outside := filepath.Join(dir, "outside.conf")
+	if err := ioutil.WriteFile(outside, []byte("$#HOST#$"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dest, "site.conf")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dest, "site.conf")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.replaceInCopy(source, dest, nil); err == nil {
+		t.Error("replaceInCopy wrote through a link")
+	}
+	if b, _ := ioutil.ReadFile(outside); string(b) != "$#HOST#$" {
+		t.Errorf("replaceInCopy changed a file outside of the bind: %q", b)
+	}
+}
@@ -0,0 +1,132 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// propdocs generates the Custom Property tables of the README from the property registry.
+//
+// Usage: go run tools/propdocs/main.go [-readme README.md]
+//
+// Without -readme the tables are printed to stdout. With -readme the section between
+// the BEGIN/END PROPERTIES markers of the given file is replaced in place.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	t "github.com/claudiobernardoromao/docker-img-deployer/types"
+)
+
+const beginMarker = "<!-- BEGIN PROPERTIES (generated by tools/propdocs, do not edit) -->"
+const endMarker = "<!-- END PROPERTIES -->"
+
+func main() {
+	readme := flag.String("readme", "", "Markdown file to update in place")
+	flag.Parse()
+
+	tables := renderTables()
+	if *readme == "" {
+		fmt.Print(tables)
+		return
+	}
+
+	b, err := ioutil.ReadFile(*readme)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	begin := bytes.Index(b, []byte(beginMarker))
+	end := bytes.Index(b, []byte(endMarker))
+	if begin == -1 || end < begin {
+		log.Fatalf("%s does not contain the property table markers\n", *readme)
+	}
+
+	var out bytes.Buffer
+	out.Write(b[:begin+len(beginMarker)])
+	out.WriteString("\n\n")
+	out.WriteString(tables)
+	out.WriteString("\n")
+	out.Write(b[end:])
+	err = ioutil.WriteFile(*readme, out.Bytes(), 0644)
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func renderTables() string {
+	var b bytes.Buffer
+	b.WriteString("### Developer Accesible Custom Properties (Visible to Developers)\n\n")
+	renderTable(&b, t.VisibilityDeveloper)
+	b.WriteString("\n### Administrative Custom Properties (Not Visible to Developers)\n\n")
+	renderTable(&b, t.VisibilityAdmin)
+
+	deprecated := [][]string{}
+	for _, p := range t.Properties {
+		for _, alias := range p.Aliases {
+			deprecated = append(deprecated, []string{alias, p.Name})
+		}
+	}
+	if len(deprecated) > 0 {
+		b.WriteString("\n### Deprecated Custom Properties\n\n")
+		b.WriteString("These names are still honored when the replacement is not set, but will be removed in a future version.\n\n")
+		b.WriteString("Property Name | Replaced By\n")
+		b.WriteString("------------- | -----------\n")
+		for _, d := range deprecated {
+			fmt.Fprintf(&b, "`%s` | `%s`\n", d[0], d[1])
+		}
+	}
+	return b.String()
+}
+
+func renderTable(b *bytes.Buffer, visibility t.Visibility) {
+	b.WriteString("Property Name | Allowed Values | Default Value | Description\n")
+	b.WriteString("------------- | -------------- | ------------- | -----------\n")
+	for _, p := range t.Properties {
+		if p.Visibility != visibility {
+			continue
+		}
+		defaultValue := "-"
+		if p.Default != "" {
+			defaultValue = "`" + p.Default + "`"
+		}
+		fmt.Fprintf(b, "`%s` | %s | %s | %s\n", p.Name, allowedValues(p), defaultValue, p.Description)
+	}
+}
+
+func allowedValues(p t.Property) string {
+	var values []string
+	switch p.Type {
+	case t.PropertyEnum:
+		values = p.Values
+	case t.PropertyBool:
+		values = []string{"Yes", "No"}
+	}
+	if len(values) > 0 {
+		return "`" + strings.Join(values, "`, `") + "`"
+	}
+	if p.Multi {
+		return "*custom*, *allow multiple*"
+	}
+	return "*custom*"
+}
@@ -0,0 +1,126 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+)
+
+var tokenRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// ParseCommand splits a command line into arguments. A value that is a JSON array of strings is
+// read as such. Otherwise it is split into words like a POSIX shell does, honoring
+// single quotes, double quotes and backslash escapes but without any other shell feature.
+// ${NAME} references outside of single quotes are replaced with tokens[NAME] when it exists.
+func ParseCommand(value string, tokens map[string]string) ([]string, error) {
+	value = strings.TrimSpace(value)
+	var args []string
+	if strings.HasPrefix(value, "[") && json.Unmarshal([]byte(value), &args) == nil {
+		for n, arg := range args {
+			args[n] = ExpandTokens(arg, tokens)
+		}
+		return args, nil
+	}
+
+	args = []string{}
+	var word bytes.Buffer
+	inWord := false
+	// Text from double quotes or outside quotes is expanded, text from single quotes is not
+	var pending bytes.Buffer
+	flush := func() {
+		word.WriteString(ExpandTokens(pending.String(), tokens))
+		pending.Reset()
+	}
+
+	for n := 0; n < len(value); n++ {
+		c := value[n]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				flush()
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case c == '\\':
+			n++
+			if n == len(value) {
+				return nil, errors.New("trailing backslash")
+			}
+			inWord = true
+			flush()
+			word.WriteByte(value[n])
+		case c == '\'':
+			end := strings.IndexByte(value[n+1:], '\'')
+			if end == -1 {
+				return nil, errors.New("unterminated single quote")
+			}
+			inWord = true
+			flush()
+			word.WriteString(value[n+1 : n+1+end])
+			n += end + 1
+		case c == '"':
+			inWord = true
+			closed := false
+			for n++; n < len(value); n++ {
+				if value[n] == '"' {
+					closed = true
+					break
+				}
+				// Inside double quotes a backslash only escapes characters special there
+				if value[n] == '\\' && n+1 < len(value) && strings.IndexByte("\"\\$`", value[n+1]) != -1 {
+					n++
+					flush()
+					word.WriteByte(value[n])
+					continue
+				}
+				pending.WriteByte(value[n])
+			}
+			if !closed {
+				return nil, errors.New("unterminated double quote")
+			}
+		default:
+			inWord = true
+			pending.WriteByte(c)
+		}
+	}
+	if inWord {
+		flush()
+		args = append(args, word.String())
+	}
+	return args, nil
+}
+
+// ExpandTokens replaces ${NAME} references with tokens[NAME], leaving unknown references as they are
+func ExpandTokens(s string, tokens map[string]string) string {
+	return tokenRef.ReplaceAllStringFunc(s, func(ref string) string {
+		if value, ok := tokens[ref[2:len(ref)-1]]; ok {
+			return value
+		}
+		return ref
+	})
+}
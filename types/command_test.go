@@ -0,0 +1,90 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	tokens := map[string]string{"PORT": "8080", "HOST": "web 1"}
+	tests := []struct {
+		value string
+		want  []string
+		err   bool
+	}{
+		{"", []string{}, false},
+		{"   ", []string{}, false},
+		{"nginx -g 'daemon off;'", []string{"nginx", "-g", "daemon off;"}, false},
+		{"  a \t b\nc  ", []string{"a", "b", "c"}, false},
+		{`echo "hello world" 'single "double"' "double 'single'"`, []string{"echo", "hello world", `single "double"`, "double 'single'"}, false},
+		{`a\ b c\\d \'e`, []string{"a b", `c\d`, "'e"}, false},
+		{`"a\"b" "c\\d" "e\nf" "g\$h"`, []string{`a"b`, `c\d`, `e\nf`, "g$h"}, false},
+		{`ab'cd'"ef"gh`, []string{"abcdefgh"}, false},
+		{`'' ""`, []string{"", ""}, false},
+		{"--port=${PORT} --host ${HOST}", []string{"--port=8080", "--host", "web 1"}, false},
+		{`"${PORT}" '${PORT}' \${PORT}`, []string{"8080", "${PORT}", "${PORT}"}, false},
+		{`"$\{PORT}"`, []string{`$\{PORT}`}, false},
+		{"${UNKNOWN} $PORT", []string{"${UNKNOWN}", "$PORT"}, false},
+		{`["nginx", "-g", "daemon off;"]`, []string{"nginx", "-g", "daemon off;"}, false},
+		{` ["run", "--port=${PORT}", "'quoted'"]`, []string{"run", "--port=8080", "'quoted'"}, false},
+		{`[]`, []string{}, false},
+		{`["unterminated"`, []string{"[unterminated"}, false},
+		{`[1, 2]`, []string{"[1,", "2]"}, false},
+		{`[ -f /x ] && exec app`, []string{"[", "-f", "/x", "]", "&&", "exec", "app"}, false},
+		{`[ -n "${PORT}" ]`, []string{"[", "-n", "8080", "]"}, false},
+		{"echo 'unterminated", nil, true},
+		{`echo "unterminated`, nil, true},
+		{`echo \`, nil, true},
+	}
+	for _, test := range tests {
+		got, err := ParseCommand(test.value, tokens)
+		if (err != nil) != test.err {
+			t.Errorf("ParseCommand(%q) error = %v, want error %v", test.value, err, test.err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseCommand(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestExpandTokens(t *testing.T) {
+	tokens := map[string]string{"A": "1", "B_2": "two", "EMPTY": ""}
+	tests := []struct {
+		s, want string
+	}{
+		{"${A}", "1"},
+		{"x${A}y${B_2}z", "x1ytwoz"},
+		{"${EMPTY}", ""},
+		{"${C} $A ${A", "${C} $A ${A"},
+		{"${1A}", "${1A}"},
+		{"$${A}", "$1"},
+	}
+	for _, test := range tests {
+		if got := ExpandTokens(test.s, tokens); got != test.want {
+			t.Errorf("ExpandTokens(%q) = %q, want %q", test.s, got, test.want)
+		}
+	}
+}
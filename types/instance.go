@@ -22,7 +22,10 @@
 
 package types
 
-import "strings"
+import (
+	"sort"
+	"strings"
+)
 
 // Instance represents an Apprenda workload instance
 type Instance struct {
@@ -93,10 +96,7 @@ type Instance struct {
 		VersionID          string `json:"versionId"`
 		VersionTenantID    string `json:"versionTenantId"`
 
-		CustomProps []struct {
-			Name   string   `json:"name"`
-			Values []string `json:"values"`
-		} `json:"customProps"`
+		CustomProps []CustomProp `json:"customProps"`
 
 		Logging struct {
 			ApprendaLoggerLevel      string `json:"apprendaLoggerLevel"`
@@ -171,6 +171,12 @@ type Instance struct {
 	} `json:"token"`
 }
 
+// CustomProp represents an Apprenda Custom Property and its values
+type CustomProp struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
 // ContainerName constructs a name for the conainer that will hold the instance
 func (i *Instance) ContainerName() string {
 	nameParts := []string{"apprenda", i.Workload.ApplicationAlias, i.Workload.VersionAlias, i.Workload.InstanceID}
@@ -206,14 +212,43 @@ func (i *Instance) GetPropFirstValue(key string) string {
 	return ""
 }
 
-// GetEnv reurns the Environment Variables and Platform Tokens
-func (i *Instance) GetEnv() ([]string, error) {
-	env := []string{}
-	for key, val := range i.Token.Tokens {
-		env = append(env, strings.Join([]string{key, val}, "="))
+// Sources of environment variables, from lowest to highest precedence
+const (
+	EnvSourceToken     = "platform token"
+	EnvSourcePlatform  = "platform environment"
+	EnvSourceDeveloper = "DockerEnv"
+)
+
+// EnvVar is an environment variable for the container and where it comes from
+type EnvVar struct {
+	Name   string
+	Value  string
+	Source string
+}
+
+// GetEnvVars returns the Platform Tokens (sorted by name), the platform Environment Variables and
+// the DockerEnv Custom Property values, in increasing order of precedence. Names may repeat.
+func (i *Instance) GetEnvVars() []EnvVar {
+	vars := []EnvVar{}
+	names := []string{}
+	for key := range i.Token.Tokens {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	for _, key := range names {
+		vars = append(vars, EnvVar{Name: key, Value: i.Token.Tokens[key], Source: EnvSourceToken})
 	}
 	for _, envVar := range i.Process.EnvironmentVariables {
-		env = append(env, strings.Join(envVar, "="))
+		if len(envVar) == 0 {
+			continue
+		}
+		vars = append(vars, EnvVar{Name: envVar[0], Value: strings.Join(envVar[1:], "="), Source: EnvSourcePlatform})
+	}
+	for _, value := range i.GetPropValues(PropDockerEnv) {
+		kv := strings.SplitN(value, "=", 2)
+		if len(kv) == 2 {
+			vars = append(vars, EnvVar{Name: kv[0], Value: ExpandTokens(kv[1], i.Token.Tokens), Source: EnvSourceDeveloper})
+		}
 	}
-	return env, nil
+	return vars
 }
@@ -0,0 +1,48 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package types
+
+// ImagePolicy restricts the images instances may deploy. Empty lists do not restrict anything.
+type ImagePolicy struct {
+	// Registry hosts images may be pulled from, e.g. "docker.io" or "registry.example.com:5000"
+	AllowedRegistries []string `json:"allowedRegistries"`
+	// Patterns for registry/repository, e.g. "docker.io/library/*"; "*" does not match "/"
+	AllowedRepositories []string `json:"allowedRepositories"`
+	// Tags that may not be deployed, keyed by stage ("Definition", "Sandbox", "Published") or "*"
+	DeniedTags map[string][]string `json:"deniedTags"`
+	// Image content digests that may not be deployed, e.g. "sha256:..."
+	DeniedDigests []string `json:"deniedDigests"`
+	// When images must carry a valid signature, nil if signatures are never required
+	RequireSignature *SignaturePolicy `json:"requireSignature"`
+}
+
+// SignaturePolicy selects the instances whose image must be signed by a trusted key.
+// An instance matches if its stage or its tenant is listed, "*" matches any value.
+type SignaturePolicy struct {
+	Stages  []string `json:"stages"`
+	Tenants []string `json:"tenants"`
+	// Directory of PEM encoded RSA or ECDSA public keys (*.pem) trusted to sign images
+	KeysDir string `json:"keysDir"`
+	// Directory of detached signatures named after the digest, e.g. sha256-<hex>.sig
+	SignaturesDir string `json:"signaturesDir"`
+}
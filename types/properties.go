@@ -0,0 +1,641 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package types
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Custom Property names
+const (
+	PropDockerDeploy                    = "DockerDeploy"
+	PropDockerImageName                 = "DockerImageName"
+	PropDockerImageTag                  = "DockerImageTag"
+	PropDockerCmd                       = "DockerCmd"
+	PropDockerEntrypoint                = "DockerEntrypoint"
+	PropDockerBindHost                  = "DockerBindHost"
+	PropDockerBindLocal                 = "DockerBindLocal"
+	PropDockerBindShared                = "DockerBindShared"
+	PropDockerBindSharedRootDir         = "DockerBindSharedRootDir"
+	PropDockerBindDirPermissions        = "DockerBindDirPermissions"
+	PropDockerBindHostApprovedDirs      = "DockerBindHostApprovedDirs"
+	PropDockerNetwork                   = "DockerNetwork"
+	PropDockerNetworkScope              = "DockerNetworkScope"
+	PropDockerReadinessCheck            = "DockerReadinessCheck"
+	PropDockerReadinessCheckPath        = "DockerReadinessCheckPath"
+	PropDockerReadinessCheckScheme      = "DockerReadinessCheckScheme"
+	PropDockerReadinessCheckTimeoutSecs = "DockerReadinessCheckTimeoutSecs"
+	PropDockerForcePull                 = "DockerForcePull"
+	PropDockerRemoveImage               = "DockerRemoveImage"
+	PropDockerRegistryUsername          = "DockerRegistryUsername"
+	PropDockerRegistryPassword          = "DockerRegistryPassword"
+	PropDockerRegistryCredentialsFile   = "DockerRegistryCredentialsFile"
+	PropDockerRegistryConfigFile        = "DockerRegistryConfigFile"
+	PropDockerPullRetries               = "DockerPullRetries"
+	PropDockerPullRetryBackoffSecs      = "DockerPullRetryBackoffSecs"
+	PropDockerPullTimeoutSecs           = "DockerPullTimeoutSecs"
+	PropDockerImagePinning              = "DockerImagePinning"
+	PropDockerImageArchive              = "DockerImageArchive"
+	PropDockerImageSignature            = "DockerImageSignature"
+	PropDockerImageGCGraceSecs          = "DockerImageGCGraceSecs"
+	PropDockerImageGCDiskThreshold      = "DockerImageGCDiskThreshold"
+	PropDockerDiskReserveMB             = "DockerDiskReserveMB"
+	PropDockerRegistryMirrors           = "DockerRegistryMirrors"
+	PropDockerInsecureRegistries        = "DockerInsecureRegistries"
+	PropDockerEnv                       = "DockerEnv"
+	PropDockerBindSharedSync            = "DockerBindSharedSync"
+	PropDockerBindOwner                 = "DockerBindOwner"
+	PropDockerBindHostReadOnlyDirs      = "DockerBindHostReadOnlyDirs"
+	PropDockerBindHostForceReadOnly     = "DockerBindHostForceReadOnly"
+	PropDockerBindSharedNoRelabel       = "DockerBindSharedNoRelabel"
+)
+
+// PropertyType is the kind of value a Custom Property holds
+type PropertyType string
+
+// Custom Property types
+const (
+	PropertyString   PropertyType = "string"
+	PropertyBool     PropertyType = "bool"
+	PropertyInt      PropertyType = "int"
+	PropertyDuration PropertyType = "duration"
+	PropertyEnum     PropertyType = "enum"
+	PropertyPath     PropertyType = "path"
+	PropertyPathList PropertyType = "pathlist"
+	PropertyHostBind PropertyType = "hostbind"
+	PropertyFileMode PropertyType = "filemode"
+	PropertyCommand  PropertyType = "command"
+	PropertyEnvVar   PropertyType = "envvar"
+	PropertyBindSync PropertyType = "bindsync"
+	PropertyOwner    PropertyType = "owner"
+)
+
+// Sync policies of shared bind initialization
+var BindSyncPolicies = []string{"Overwrite", "IfEmpty", "MissingOnly", "Once"}
+
+// Visibility tells who is expected to set a Custom Property
+type Visibility string
+
+// Custom Property visibilities
+const (
+	VisibilityDeveloper Visibility = "developer"
+	VisibilityAdmin     Visibility = "admin"
+)
+
+// Property describes a Custom Property understood by the deployer
+type Property struct {
+	Name        string
+	Type        PropertyType
+	Values      []string // Allowed values of an enum
+	Default     string
+	Multi       bool // Whether multiple values are used
+	Visibility  Visibility
+	Aliases     []string // Deprecated names, used only if Name is not set
+	Description string
+}
+
+// Properties is the registry of all Custom Properties understood by the deployer
+var Properties = []Property{
+	{
+		Name:        PropDockerDeploy,
+		Type:        PropertyEnum,
+		Values:      []string{"No", "Dockerfile", "Registry"},
+		Default:     "No",
+		Visibility:  VisibilityDeveloper,
+		Description: "Used to trigger the Bootstrapper; Registry pulls the image, Dockerfile builds it from the archive",
+	},
+	{
+		Name:        PropDockerImageName,
+		Type:        PropertyString,
+		Visibility:  VisibilityDeveloper,
+		Description: "The name of the image to pull from the registry",
+	},
+	{
+		Name:        PropDockerImageTag,
+		Type:        PropertyString,
+		Default:     "latest",
+		Visibility:  VisibilityDeveloper,
+		Description: "The specific image tag to use when pulling",
+	},
+	{
+		Name:        PropDockerImageArchive,
+		Type:        PropertyString,
+		Visibility:  VisibilityDeveloper,
+		Description: "A docker save tarball in the component folder of the archive to load instead of pulling the image",
+	},
+	{
+		Name:        PropDockerImageSignature,
+		Type:        PropertyString,
+		Visibility:  VisibilityDeveloper,
+		Description: "A detached signature of the image digest in the component folder of the archive",
+	},
+	{
+		Name:        PropDockerCmd,
+		Type:        PropertyCommand,
+		Multi:       true,
+		Visibility:  VisibilityDeveloper,
+		Description: "Override the command and arguments to invoke inside the container, as shell words or a JSON array",
+	},
+	{
+		Name:        PropDockerEntrypoint,
+		Type:        PropertyCommand,
+		Multi:       true,
+		Visibility:  VisibilityDeveloper,
+		Description: "Override the entrypoint set inside the container, as shell words or a JSON array",
+	},
+	{
+		Name:        PropDockerEnv,
+		Type:        PropertyEnvVar,
+		Multi:       true,
+		Visibility:  VisibilityDeveloper,
+		Description: "Environment variable to set in the container, as NAME=value, overriding platform variables",
+	},
+	{
+		Name:        PropDockerBindHost,
+		Type:        PropertyHostBind,
+		Multi:       true,
+		Visibility:  VisibilityDeveloper,
+		Description: "Local host directory absolute path to mount",
+	},
+	{
+		Name:        PropDockerBindLocal,
+		Type:        PropertyPath,
+		Multi:       true,
+		Visibility:  VisibilityDeveloper,
+		Description: "Instance-space sub-directory path to mount",
+	},
+	{
+		Name:        PropDockerBindShared,
+		Type:        PropertyPath,
+		Multi:       true,
+		Visibility:  VisibilityDeveloper,
+		Description: "Global-space sub-directory path to mount",
+	},
+	{
+		Name:        PropDockerBindSharedSync,
+		Type:        PropertyBindSync,
+		Multi:       true,
+		Visibility:  VisibilityDeveloper,
+		Description: "How archive content is copied into shared mounts: Overwrite (default), IfEmpty, MissingOnly or Once, for all mounts or as /container/path=Policy",
+	},
+	{
+		Name:        PropDockerNetwork,
+		Type:        PropertyString,
+		Visibility:  VisibilityDeveloper,
+		Description: "The network name to use for the container",
+	},
+	{
+		Name:        PropDockerNetworkScope,
+		Type:        PropertyEnum,
+		Values:      []string{"App", "Tenant", "Global"},
+		Visibility:  VisibilityDeveloper,
+		Description: "Use overlay networking with this scope",
+	},
+	{
+		Name:        PropDockerReadinessCheck,
+		Type:        PropertyBool,
+		Default:     "No",
+		Visibility:  VisibilityDeveloper,
+		Aliases:     []string{"DockerHealthCheck"},
+		Description: "Whether health checks should be performed before routing traffic to instance",
+	},
+	{
+		Name:        PropDockerReadinessCheckPath,
+		Type:        PropertyPath,
+		Default:     "/",
+		Visibility:  VisibilityDeveloper,
+		Aliases:     []string{"DockerHealthCheckPath"},
+		Description: "A URL path to check for HTTP response codes < 300",
+	},
+	{
+		Name:        PropDockerReadinessCheckScheme,
+		Type:        PropertyEnum,
+		Values:      []string{"http", "https"},
+		Default:     "http",
+		Visibility:  VisibilityDeveloper,
+		Aliases:     []string{"DockerHealthCheckScheme"},
+		Description: "The scheme that should be used for checks",
+	},
+	{
+		Name:        PropDockerReadinessCheckTimeoutSecs,
+		Type:        PropertyDuration,
+		Default:     "300",
+		Visibility:  VisibilityDeveloper,
+		Aliases:     []string{"DockerHealthCheckTimeoutSecs"},
+		Description: "Abort deployment after this timeout in seconds",
+	},
+	{
+		Name:        PropDockerRegistryUsername,
+		Type:        PropertyString,
+		Visibility:  VisibilityDeveloper,
+		Description: "User name to authenticate with the image's registry",
+	},
+	{
+		Name:        PropDockerRegistryPassword,
+		Type:        PropertyString,
+		Visibility:  VisibilityDeveloper,
+		Description: "Password for `DockerRegistryUsername`, encrypted with the platform's claim encryption key",
+	},
+	{
+		Name:        PropDockerForcePull,
+		Type:        PropertyBool,
+		Default:     "No",
+		Visibility:  VisibilityAdmin,
+		Description: "Should a pull be forced for every deployment",
+	},
+	{
+		Name:        PropDockerImagePinning,
+		Type:        PropertyEnum,
+		Values:      []string{"Always", "NotInSandbox", "Never"},
+		Default:     "Always",
+		Visibility:  VisibilityAdmin,
+		Description: "When to pin all instances of an application version to the image digest resolved on first deploy",
+	},
+	{
+		Name:        PropDockerRegistryMirrors,
+		Type:        PropertyString,
+		Multi:       true,
+		Visibility:  VisibilityAdmin,
+		Description: "Pull through a mirror first, as registry=mirror pairs, e.g. docker.io=mirror.example.com:5000",
+	},
+	{
+		Name:        PropDockerInsecureRegistries,
+		Type:        PropertyString,
+		Multi:       true,
+		Visibility:  VisibilityAdmin,
+		Description: "Registry hosts that may be reached over plain HTTP, outside of the Published stage only",
+	},
+	{
+		Name:        PropDockerPullRetries,
+		Type:        PropertyInt,
+		Default:     "3",
+		Visibility:  VisibilityAdmin,
+		Description: "How many times a failed image pull is retried",
+	},
+	{
+		Name:        PropDockerPullRetryBackoffSecs,
+		Type:        PropertyDuration,
+		Default:     "5",
+		Visibility:  VisibilityAdmin,
+		Description: "Seconds to wait before the first pull retry, doubled for every further retry",
+	},
+	{
+		Name:        PropDockerPullTimeoutSecs,
+		Type:        PropertyDuration,
+		Default:     "1800",
+		Visibility:  VisibilityAdmin,
+		Description: "Abort deployment if the image pull, including retries, takes longer than this in seconds",
+	},
+	{
+		Name:        PropDockerRemoveImage,
+		Type:        PropertyBool,
+		Default:     "No",
+		Visibility:  VisibilityAdmin,
+		Aliases:     []string{"DockerImageRemove"},
+		Description: "Should the cached image be removed when no containers are left using it, without waiting for the grace period",
+	},
+	{
+		Name:        PropDockerDiskReserveMB,
+		Type:        PropertyInt,
+		Default:     "1024",
+		Visibility:  VisibilityAdmin,
+		Description: "Abort deployment if pulling the image or copying archive content would leave less than this many MiB free",
+	},
+	{
+		Name:        PropDockerImageGCGraceSecs,
+		Type:        PropertyDuration,
+		Default:     "86400",
+		Visibility:  VisibilityAdmin,
+		Description: "Remove images obtained by the deployer once no container has used them for this many seconds",
+	},
+	{
+		Name:        PropDockerImageGCDiskThreshold,
+		Type:        PropertyInt,
+		Default:     "85",
+		Visibility:  VisibilityAdmin,
+		Description: "Remove unused images obtained by the deployer, least recently used first, while the Docker disk is fuller than this percentage (0 disables)",
+	},
+	{
+		Name:        PropDockerRegistryCredentialsFile,
+		Type:        PropertyPath,
+		Default:     "/etc/apprenda/docker-registry-credentials.json",
+		Visibility:  VisibilityAdmin,
+		Description: "Host file with registry credentials, keyed by registry host",
+	},
+	{
+		Name:        PropDockerRegistryConfigFile,
+		Type:        PropertyPath,
+		Default:     "/root/.docker/config.json",
+		Visibility:  VisibilityAdmin,
+		Description: "Docker client configuration file whose `auths` are used for registry credentials",
+	},
+	{
+		Name:        PropDockerBindSharedRootDir,
+		Type:        PropertyPath,
+		Default:     "/apprenda/docker-binds",
+		Visibility:  VisibilityAdmin,
+		Description: "The Shared root path for binds",
+	},
+	{
+		Name:        PropDockerBindOwner,
+		Type:        PropertyOwner,
+		Visibility:  VisibilityDeveloper,
+		Description: "Numeric uid[:gid] to own local and shared mount directories and the archive content copied into them",
+	},
+	{
+		Name:        PropDockerBindDirPermissions,
+		Type:        PropertyFileMode,
+		Default:     "0777",
+		Visibility:  VisibilityAdmin,
+		Description: "Force specific permissions on bind directory creation",
+	},
+	{
+		Name:        PropDockerBindHostApprovedDirs,
+		Type:        PropertyPathList,
+		Visibility:  VisibilityAdmin,
+		Description: "Colon-separated white list of approved absolute paths for host bind mounting",
+	},
+	{
+		Name:        PropDockerBindHostReadOnlyDirs,
+		Type:        PropertyPathList,
+		Visibility:  VisibilityAdmin,
+		Description: "Colon-separated white list of absolute paths that may only be host bind mounted read-only",
+	},
+	{
+		Name:        PropDockerBindHostForceReadOnly,
+		Type:        PropertyBool,
+		Default:     "No",
+		Visibility:  VisibilityAdmin,
+		Description: "Mount all host binds read-only",
+	},
+	{
+		Name:        PropDockerBindSharedNoRelabel,
+		Type:        PropertyBool,
+		Default:     "No",
+		Visibility:  VisibilityAdmin,
+		Description: "Reject the z and Z SELinux relabeling options on shared binds",
+	},
+}
+
+// LookupProperty finds a Custom Property by name or deprecated alias.
+// The second return value is true if name is a deprecated alias.
+func LookupProperty(name string) (*Property, bool) {
+	for n := range Properties {
+		p := &Properties[n]
+		if p.Name == name {
+			return p, false
+		}
+		for _, alias := range p.Aliases {
+			if alias == name {
+				return p, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func mustLookupProperty(name string) *Property {
+	p, _ := LookupProperty(name)
+	if p == nil {
+		panic(fmt.Sprintf("Custom Property %q is not registered", name))
+	}
+	return p
+}
+
+// ParseBool interprets the truthy and falsy values accepted by boolean Custom Properties
+func ParseBool(value string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "yes", "true", "on", "1":
+		return true, nil
+	case "no", "false", "off", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("value %q is not one of Yes, No", value)
+}
+
+// Validate checks a single value against the property's type
+func (p *Property) Validate(value string) error {
+	switch p.Type {
+	case PropertyBool:
+		_, err := ParseBool(value)
+		return err
+	case PropertyInt:
+		if n, err := strconv.Atoi(value); err != nil || n < 0 {
+			return fmt.Errorf("value %q is not a whole number", value)
+		}
+	case PropertyDuration:
+		if n, err := strconv.Atoi(value); err != nil || n <= 0 {
+			return fmt.Errorf("value %q is not a positive whole number", value)
+		}
+	case PropertyEnum:
+		if p.canonicalValue(value) == "" {
+			return fmt.Errorf("value %q is not one of %s", value, strings.Join(p.Values, ", "))
+		}
+	case PropertyPath:
+		if !strings.HasPrefix(value, "/") {
+			return fmt.Errorf("value %q is not an absolute path", value)
+		}
+	case PropertyPathList:
+		for _, path := range strings.Split(value, ":") {
+			if !strings.HasPrefix(path, "/") {
+				return fmt.Errorf("%q in %q is not an absolute path", path, value)
+			}
+		}
+	case PropertyHostBind:
+		parts := strings.Split(value, ":")
+		if len(parts) < 2 || !strings.HasPrefix(parts[0], "/") || !strings.HasPrefix(parts[1], "/") {
+			return fmt.Errorf("value %q is not of the form /host/path:/container/path", value)
+		}
+	case PropertyFileMode:
+		if mode, err := strconv.ParseUint(value, 8, 32); err != nil || mode > 0777 {
+			return fmt.Errorf("value %q is not an octal permission mode such as 0777", value)
+		}
+	case PropertyEnvVar:
+		if n := strings.Index(value, "="); n <= 0 {
+			return fmt.Errorf("value %q is not of the form NAME=value", value)
+		}
+	case PropertyOwner:
+		for _, id := range strings.SplitN(value, ":", 2) {
+			if n, err := strconv.Atoi(id); err != nil || n < 0 {
+				return fmt.Errorf("value %q is not a numeric uid[:gid]", value)
+			}
+		}
+	case PropertyBindSync:
+		if ParseBindSync(value).Policy == "" {
+			return fmt.Errorf("value %q is not a sync policy (%s), optionally preceded by /container/path=", value, strings.Join(BindSyncPolicies, ", "))
+		}
+	case PropertyCommand:
+		if _, err := ParseCommand(value, nil); err != nil {
+			return fmt.Errorf("value %q is not a valid command: %s", value, err)
+		}
+	}
+	return nil
+}
+
+// canonicalValue returns the allowed enum value matching value regardless of case
+func (p *Property) canonicalValue(value string) string {
+	for _, allowed := range p.Values {
+		if strings.EqualFold(value, allowed) {
+			return allowed
+		}
+	}
+	return ""
+}
+
+// GetPropValues returns the non-empty values of a registered property, falling back to its
+// deprecated aliases and then to its default. A property with only empty values is unset.
+func (i *Instance) GetPropValues(key string) []string {
+	p := mustLookupProperty(key)
+	for _, name := range append([]string{p.Name}, p.Aliases...) {
+		values := []string{}
+		for _, value := range i.GetProp(name) {
+			if value != "" {
+				values = append(values, value)
+			}
+		}
+		if len(values) > 0 {
+			return values
+		}
+	}
+	if p.Default != "" {
+		return []string{p.Default}
+	}
+	return []string{}
+}
+
+// GetPropString returns the first value of a registered property or its default
+func (i *Instance) GetPropString(key string) string {
+	values := i.GetPropValues(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// GetPropValid returns the first value of a registered property if it is valid, or its default otherwise
+func (i *Instance) GetPropValid(key string) string {
+	p := mustLookupProperty(key)
+	value := i.GetPropString(key)
+	if value != "" && p.Validate(value) != nil {
+		return p.Default
+	}
+	return value
+}
+
+// GetPropBool returns the value of a boolean property, or its default if unset or invalid
+func (i *Instance) GetPropBool(key string) bool {
+	b, _ := ParseBool(i.GetPropValid(key))
+	return b
+}
+
+// GetPropInt returns the value of an integer property, or its default if unset or invalid
+func (i *Instance) GetPropInt(key string) int {
+	n, _ := strconv.Atoi(i.GetPropValid(key))
+	return n
+}
+
+// GetPropDuration returns the value of a property holding a number of seconds,
+// or its default if unset or invalid
+func (i *Instance) GetPropDuration(key string) time.Duration {
+	return time.Duration(i.GetPropInt(key)) * time.Second
+}
+
+// GetPropEnum returns the canonical value of an enum property, or its default if unset or invalid
+func (i *Instance) GetPropEnum(key string) string {
+	p := mustLookupProperty(key)
+	return p.canonicalValue(i.GetPropValid(key))
+}
+
+// GetPropPathList returns the paths of a colon-separated path list property
+func (i *Instance) GetPropPathList(key string) []string {
+	value := i.GetPropValid(key)
+	if value == "" {
+		return []string{}
+	}
+	return strings.Split(value, ":")
+}
+
+// GetPropFileMode returns the value of an octal permissions property, or its default if unset or invalid
+func (i *Instance) GetPropFileMode(key string) os.FileMode {
+	mode, _ := strconv.ParseUint(i.GetPropValid(key), 8, 32)
+	return os.FileMode(mode)
+}
+
+// GetPropCommand returns the arguments of a command property. A single value is parsed with
+// ParseCommand, several values are taken as one argument each. Platform tokens are expanded.
+func (i *Instance) GetPropCommand(key string) ([]string, error) {
+	values := i.GetPropValues(key)
+	if len(values) == 1 {
+		return ParseCommand(values[0], i.Token.Tokens)
+	}
+	args := []string{}
+	for _, value := range values {
+		args = append(args, ExpandTokens(value, i.Token.Tokens))
+	}
+	return args, nil
+}
+
+// BindSync is a sync policy for the shared mount at Path, or for all shared mounts if Path is empty
+type BindSync struct {
+	Path   string
+	Policy string
+}
+
+// ParseBindSync parses a "[/container/path=]Policy" value. Policy is empty if it is not valid.
+func ParseBindSync(value string) BindSync {
+	sync := BindSync{}
+	if n := strings.LastIndex(value, "="); n != -1 {
+		sync.Path, value = value[:n], value[n+1:]
+		if !strings.HasPrefix(sync.Path, "/") {
+			return BindSync{}
+		}
+	}
+	for _, policy := range BindSyncPolicies {
+		if strings.EqualFold(policy, strings.TrimSpace(value)) {
+			sync.Policy = policy
+		}
+	}
+	return sync
+}
+
+// GetBindSyncPolicy returns the sync policy of the shared mount at containerPath
+func (i *Instance) GetBindSyncPolicy(containerPath string) string {
+	policy := BindSyncPolicies[0]
+	for _, value := range i.GetPropValues(PropDockerBindSharedSync) {
+		sync := ParseBindSync(value)
+		if sync.Policy == "" {
+			continue
+		}
+		if sync.Path == "" {
+			policy = sync.Policy
+		} else if path.Clean(sync.Path) == path.Clean(containerPath) {
+			return sync.Policy
+		}
+	}
+	return policy
+}
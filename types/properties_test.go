@@ -0,0 +1,61 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetPropValues(t *testing.T) {
+	tests := []struct {
+		props []CustomProp
+		key   string
+		want  []string
+	}{
+		{nil, PropDockerImageTag, []string{"latest"}},
+		{[]CustomProp{{Name: PropDockerImageTag, Values: []string{"1.11"}}}, PropDockerImageTag, []string{"1.11"}},
+		{[]CustomProp{{Name: PropDockerImageTag, Values: []string{""}}}, PropDockerImageTag, []string{"latest"}},
+		{[]CustomProp{{Name: PropDockerImageTag, Values: []string{}}}, PropDockerImageTag, []string{"latest"}},
+		{[]CustomProp{{Name: PropDockerBindSharedRootDir, Values: []string{""}}}, PropDockerBindSharedRootDir, []string{"/apprenda/docker-binds"}},
+		{[]CustomProp{{Name: PropDockerBindLocal, Values: []string{"", "/a", "", "/b"}}}, PropDockerBindLocal, []string{"/a", "/b"}},
+		{[]CustomProp{{Name: PropDockerBindLocal, Values: []string{""}}}, PropDockerBindLocal, []string{}},
+		{[]CustomProp{{Name: "DockerHealthCheckPath", Values: []string{"/health"}}}, PropDockerReadinessCheckPath, []string{"/health"}},
+		{[]CustomProp{{Name: PropDockerReadinessCheckPath, Values: []string{""}}, {Name: "DockerHealthCheckPath", Values: []string{"/health"}}}, PropDockerReadinessCheckPath, []string{"/health"}},
+	}
+	for _, test := range tests {
+		i := &Instance{}
+		i.Workload.CustomProps = test.props
+		if got := i.GetPropValues(test.key); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("GetPropValues(%s) with %+v = %q, want %q", test.key, test.props, got, test.want)
+		}
+	}
+}
+
+func TestGetPropValidEmpty(t *testing.T) {
+	i := &Instance{}
+	i.Workload.CustomProps = []CustomProp{{Name: PropDockerImageTag, Values: []string{""}}}
+	if got := i.GetPropValid(PropDockerImageTag); got != "latest" {
+		t.Errorf("GetPropValid(%s) with an empty value = %q, want the default", PropDockerImageTag, got)
+	}
+}
@@ -0,0 +1,76 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package types
+
+import "time"
+
+// State records what deploy actually created for an instance, so that later
+// lifecycle events do not depend on Custom Properties that may have changed since
+type State struct {
+	DeployerVersion string         `json:"deployerVersion"`
+	ContainerID     string         `json:"containerId"`
+	ContainerName   string         `json:"containerName"`
+	Image           string         `json:"image"`
+	ImageID         string         `json:"imageId,omitempty"`
+	ImageDigest     string         `json:"imageDigest,omitempty"`
+	Networks        []StateNetwork `json:"networks,omitempty"`
+	Binds           []StateBind    `json:"binds,omitempty"`
+	ForwarderPid    int            `json:"forwarderPid,omitempty"`
+	History         []StateEvent   `json:"history"`
+}
+
+// StateNetwork is a Docker network the instance container was attached to
+type StateNetwork struct {
+	Name string `json:"name"`
+	// Scoped networks are created by the deployer and removed on undeploy
+	Scoped bool `json:"scoped"`
+}
+
+// StateBind is a host directory bind mounted into the instance container
+type StateBind struct {
+	Type          string `json:"type"`
+	HostPath      string `json:"hostPath"`
+	ContainerPath string `json:"containerPath"`
+}
+
+// StateEvent is an entry in the audit trail of an instance
+type StateEvent struct {
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event"`
+	Details string    `json:"details,omitempty"`
+}
+
+// ImagePin records the image digest that all instances of a component version deploy
+type ImagePin struct {
+	Ref        string    `json:"ref"`
+	Digest     string    `json:"digest"`
+	PinnedAt   time.Time `json:"pinnedAt"`
+	InstanceID string    `json:"instanceId"`
+}
+
+// TrackedImage is an image the deployer pulled, loaded or built on the host
+type TrackedImage struct {
+	Refs       []string  `json:"refs"`
+	AcquiredAt time.Time `json:"acquiredAt"`
+	LastUsed   time.Time `json:"lastUsed"`
+}
@@ -0,0 +1,89 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package types
+
+import "time"
+
+// Status represents the observed state of a deployed workload instance
+type Status struct {
+	InstanceID    string           `json:"instanceId"`
+	ContainerName string           `json:"containerName"`
+	Image         string           `json:"image"`
+	Container     ContainerStatus  `json:"container"`
+	Network       *NetworkStatus   `json:"network,omitempty"`
+	Binds         []BindStatus     `json:"binds"`
+	LogForwarder  ForwarderStatus  `json:"logForwarder"`
+	Readiness     *ReadinessResult `json:"readiness,omitempty"`
+	State         *State           `json:"state,omitempty"`
+}
+
+// ContainerStatus holds the Docker state of the instance container
+type ContainerStatus struct {
+	Exists     bool   `json:"exists"`
+	ID         string `json:"id,omitempty"`
+	Image      string `json:"image,omitempty"`
+	State      string `json:"state,omitempty"`
+	Running    bool   `json:"running"`
+	Pid        int    `json:"pid,omitempty"`
+	ExitCode   int    `json:"exitCode"`
+	Error      string `json:"error,omitempty"`
+	StartedAt  string `json:"startedAt,omitempty"`
+	FinishedAt string `json:"finishedAt,omitempty"`
+	Health     string `json:"health,omitempty"`
+}
+
+// NetworkStatus holds the Docker state of the instance's scoped network
+type NetworkStatus struct {
+	Name      string `json:"name"`
+	Exists    bool   `json:"exists"`
+	ID        string `json:"id,omitempty"`
+	Driver    string `json:"driver,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	Connected bool   `json:"connected"`
+}
+
+// BindStatus describes a host directory bind mounted into the container
+type BindStatus struct {
+	Type          string `json:"type"`
+	HostPath      string `json:"hostPath"`
+	ContainerPath string `json:"containerPath"`
+	Exists        bool   `json:"exists"`
+}
+
+// ForwarderStatus holds the state of the logstash-forwarder process for the instance
+type ForwarderStatus struct {
+	PidFile string `json:"pidFile"`
+	Pid     int    `json:"pid,omitempty"`
+	Running bool   `json:"running"`
+}
+
+// ReadinessResult records the outcome of the last readiness check
+type ReadinessResult struct {
+	URL         string    `json:"url"`
+	Passed      bool      `json:"passed"`
+	Attempts    int       `json:"attempts"`
+	StatusCode  int       `json:"statusCode,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	ElapsedSecs float64   `json:"elapsedSecs"`
+	CheckedAt   time.Time `json:"checkedAt"`
+}
@@ -0,0 +1,296 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2016 Apprenda Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	t "github.com/claudiobernardoromao/docker-img-deployer/types"
+)
+
+// validationIssue is a problem found with a component's Custom Properties
+type validationIssue struct {
+	Component string
+	Property  string
+	Severity  string
+	Message   string
+}
+
+func (v validationIssue) String() string {
+	if v.Component != "" {
+		return fmt.Sprintf("%-7s [%s] %s: %s", v.Severity, v.Component, v.Property, v.Message)
+	}
+	return fmt.Sprintf("%-7s %s: %s", v.Severity, v.Property, v.Message)
+}
+
+// validateProperties checks the Custom Properties in an instance.json or DeploymentManifest.xml
+// file and prints every issue found. It returns an error if any issue was found.
+func validateProperties(args []string) error {
+	path := filepath.Join("..", instanceJSONFileName)
+	if len(args) > 0 {
+		path = args[0]
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	instances := map[string]*t.Instance{}
+	fromManifest := strings.HasSuffix(strings.ToLower(path), ".xml")
+	if fromManifest {
+		instances, err = readManifestInstances(b)
+		if err != nil {
+			return err
+		}
+	} else {
+		var i t.Instance
+		err = json.Unmarshal(b, &i)
+		if err != nil {
+			return err
+		}
+		instances[""] = &i
+	}
+
+	components := []string{}
+	for component := range instances {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+
+	issues := []validationIssue{}
+	for _, component := range components {
+		for _, issue := range validateInstance(instances[component], fromManifest) {
+			issue.Component = component
+			issues = append(issues, issue)
+		}
+	}
+
+	for _, issue := range issues {
+		fmt.Println(issue)
+	}
+	if len(issues) > 0 {
+		return fmt.Errorf("%s: %d Custom Property issue(s) found", path, len(issues))
+	}
+	fmt.Printf("%s: no Custom Property issues found\n", path)
+	return nil
+}
+
+// deploymentManifest is the subset of an Apprenda DeploymentManifest.xml holding Linux component properties
+type deploymentManifest struct {
+	LinuxServices []struct {
+		Name        string `xml:"name,attr"`
+		CustomProps []struct {
+			Name   string `xml:"name,attr"`
+			Values []struct {
+				Value string `xml:"value,attr"`
+			} `xml:"values>propertyValue"`
+		} `xml:"customProperties>customProperty"`
+	} `xml:"linuxServices>service"`
+}
+
+// readManifestInstances builds a minimal instance for each Linux service declared in a deployment manifest
+func readManifestInstances(b []byte) (map[string]*t.Instance, error) {
+	var manifest deploymentManifest
+	err := xml.Unmarshal(b, &manifest)
+	if err != nil {
+		return nil, err
+	}
+	if len(manifest.LinuxServices) == 0 {
+		return nil, fmt.Errorf("No linuxServices components declared in the deployment manifest")
+	}
+
+	instances := map[string]*t.Instance{}
+	for _, service := range manifest.LinuxServices {
+		i := &t.Instance{}
+		i.Workload.BundleName = service.Name
+		for _, prop := range service.CustomProps {
+			values := []string{}
+			for _, v := range prop.Values {
+				values = append(values, v.Value)
+			}
+			i.Workload.CustomProps = append(i.Workload.CustomProps, t.CustomProp{Name: prop.Name, Values: values})
+		}
+		instances[service.Name] = i
+	}
+	return instances, nil
+}
+
+func validateInstance(i *t.Instance, fromManifest bool) []validationIssue {
+	issues := []validationIssue{}
+	report := func(prop, severity, format string, a ...interface{}) {
+		issues = append(issues, validationIssue{Property: prop, Severity: severity, Message: fmt.Sprintf(format, a...)})
+	}
+
+	for _, prop := range i.Workload.CustomProps {
+		if !strings.HasPrefix(prop.Name, "Docker") {
+			continue
+		}
+		p, deprecated := t.LookupProperty(prop.Name)
+		if p == nil {
+			report(prop.Name, "ERROR", "unknown property, it will be ignored by the deployer")
+			continue
+		}
+		if deprecated {
+			report(prop.Name, "WARNING", "deprecated, use %s instead", p.Name)
+		}
+		if fromManifest && p.Visibility == t.VisibilityAdmin {
+			report(prop.Name, "WARNING", "administrative property, normally set by platform operators")
+		}
+		if len(prop.Values) > 1 && !p.Multi {
+			report(prop.Name, "WARNING", "only the first of %d values will be used", len(prop.Values))
+		}
+		for _, value := range prop.Values {
+			if err := p.Validate(value); err != nil {
+				if p.Default != "" {
+					report(prop.Name, "ERROR", "%s, the default %q would be used", err, p.Default)
+				} else {
+					report(prop.Name, "ERROR", "%s", err)
+				}
+			}
+		}
+	}
+
+	// Deprecated names are only honored when the current name is not set
+	for _, p := range t.Properties {
+		newValue := i.GetPropFirstValue(p.Name)
+		for _, alias := range p.Aliases {
+			oldValue := i.GetPropFirstValue(alias)
+			if oldValue != "" && newValue != "" && oldValue != newValue {
+				report(alias, "ERROR", "conflicts with %s (%q vs %q), the value of %s will be used", p.Name, oldValue, newValue, p.Name)
+			}
+		}
+	}
+
+	if isDockerfileDeploy(i) {
+		if i.GetPropString(t.PropDockerImageName) != "" {
+			report(t.PropDockerImageName, "WARNING", "ignored because %s is Dockerfile", t.PropDockerDeploy)
+		}
+	} else if i.GetPropString(t.PropDockerImageName) == "" {
+		report(t.PropDockerImageName, "ERROR", "required, must be populated with a valid image name")
+	}
+
+	switch i.GetPropEnum(t.PropDockerNetworkScope) {
+	case "Tenant", "Global":
+		if i.GetPropString(t.PropDockerNetwork) == "" {
+			report(t.PropDockerNetworkScope, "ERROR", "%s scope requires %s to be set", i.GetPropEnum(t.PropDockerNetworkScope), t.PropDockerNetwork)
+		}
+	case "App":
+		if i.GetPropString(t.PropDockerNetwork) != "" {
+			report(t.PropDockerNetwork, "WARNING", "ignored because %s is App", t.PropDockerNetworkScope)
+		}
+	}
+
+	// Operators set the approved directories, so they are usually absent from a manifest
+	if len(i.GetPropValues(t.PropDockerBindHost)) > 0 && len(i.GetPropPathList(t.PropDockerBindHostApprovedDirs)) == 0 && len(i.GetPropPathList(t.PropDockerBindHostReadOnlyDirs)) == 0 && !fromManifest {
+		report(t.PropDockerBindHost, "ERROR", "host binding is not allowed unless %s or %s is set", t.PropDockerBindHostApprovedDirs, t.PropDockerBindHostReadOnlyDirs)
+	}
+
+	if (i.GetPropString(t.PropDockerRegistryUsername) == "") != (i.GetPropString(t.PropDockerRegistryPassword) == "") {
+		report(t.PropDockerRegistryUsername, "ERROR", "%s and %s must be set together", t.PropDockerRegistryUsername, t.PropDockerRegistryPassword)
+	}
+
+	for _, value := range i.GetPropValues(t.PropDockerRegistryMirrors) {
+		kv := strings.SplitN(value, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+			report(t.PropDockerRegistryMirrors, "ERROR", "%q must be a registry=mirror pair", value)
+		}
+	}
+	if len(i.GetPropValues(t.PropDockerInsecureRegistries)) > 0 && strings.EqualFold(i.Workload.Stage.Value, "Published") {
+		report(t.PropDockerInsecureRegistries, "WARNING", "ignored in the Published stage")
+	}
+
+	if archive := i.GetPropString(t.PropDockerImageArchive); archive != "" {
+		if filepath.IsAbs(archive) || strings.HasPrefix(filepath.Clean(archive), "..") {
+			report(t.PropDockerImageArchive, "ERROR", "%q must be a path inside the component folder of the archive", archive)
+		}
+		if i.GetPropBool(t.PropDockerForcePull) {
+			report(t.PropDockerForcePull, "WARNING", "ignored because %s is set", t.PropDockerImageArchive)
+		}
+	}
+
+	shared := map[string]bool{}
+	for _, p := range i.GetPropValues(t.PropDockerBindShared) {
+		shared[path.Clean(strings.SplitN(p, ":", 2)[0])] = true
+	}
+	for _, value := range i.GetPropValues(t.PropDockerBindSharedSync) {
+		if sync := t.ParseBindSync(value); sync.Path != "" && !shared[path.Clean(sync.Path)] {
+			report(t.PropDockerBindSharedSync, "WARNING", "%q is not declared in %s", sync.Path, t.PropDockerBindShared)
+		}
+	}
+
+	for _, prop := range []string{t.PropDockerBindLocal, t.PropDockerBindShared, t.PropDockerBindHost} {
+		for _, value := range i.GetPropValues(prop) {
+			if hasDotDot(value) {
+				report(prop, "ERROR", "%q must not contain ..", value)
+			}
+		}
+	}
+
+	for _, prop := range []string{t.PropDockerBindLocal, t.PropDockerBindShared, t.PropDockerBindHost} {
+		fields := 2
+		if prop == t.PropDockerBindHost {
+			fields = 3
+		}
+		for _, value := range i.GetPropValues(prop) {
+			parts := strings.SplitN(value, ":", fields)
+			if len(parts) < fields {
+				continue
+			}
+			opts, err := parseBindOptions(parts[fields-1])
+			if err != nil {
+				report(prop, "ERROR", "%q: %s", value, err)
+				continue
+			}
+			if prop == t.PropDockerBindShared && opts.Relabel != "" {
+				if i.GetPropBool(t.PropDockerBindSharedNoRelabel) {
+					report(prop, "ERROR", "%q cannot be relabeled because %s is set", value, t.PropDockerBindSharedNoRelabel)
+				} else if opts.Relabel == "Z" {
+					report(prop, "WARNING", "%q uses a private SELinux label, other instances sharing it will lose access", value)
+				}
+			}
+			if prop == t.PropDockerBindHost && opts.Mode == "rw" && i.GetPropBool(t.PropDockerBindHostForceReadOnly) {
+				report(prop, "ERROR", "%q cannot be read-write because %s is set", value, t.PropDockerBindHostForceReadOnly)
+			}
+		}
+	}
+
+	local := map[string]bool{}
+	for _, path := range i.GetPropValues(t.PropDockerBindLocal) {
+		local[path] = true
+	}
+	for _, path := range i.GetPropValues(t.PropDockerBindShared) {
+		if local[path] {
+			report(t.PropDockerBindShared, "ERROR", "%q is also declared in %s", path, t.PropDockerBindLocal)
+		}
+	}
+
+	return issues
+}